@@ -0,0 +1,193 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+
+    "github.com/crides/gysp/eval"
+    "github.com/crides/gysp/color"
+    "github.com/crides/gysp/line"
+)
+
+// The REPL side of the debugger: when an evaluation stops, a debug> prompt
+// takes the commands below, and evaluates anything else in the paused frame
+
+// A command at the debug> prompt; it returns whether to go on, and how
+type debug_command struct {
+    names   []string
+    help    string
+    run     func(r *repl, s *eval.Stop) (eval.Step, bool)
+}
+
+var debug_commands []*debug_command
+
+func init() {
+    resume := func(step eval.Step) func(*repl, *eval.Stop) (eval.Step, bool) {
+        return func(*repl, *eval.Stop) (eval.Step, bool) {
+            return step, true
+        }
+    }
+    debug_commands = []*debug_command{
+        {[]string{"step", "s"}, "Step into the next form", resume(eval.STEP_IN)},
+        {[]string{"next", "n"}, "Step over the current form", resume(eval.STEP_OVER)},
+        {[]string{"out", "o"}, "Run until the current function returns", resume(eval.STEP_OUT)},
+        {[]string{"continue", "c"}, "Run until the next breakpoint", resume(eval.STEP_CONTINUE)},
+        {[]string{"where", "bt"}, "Show the call stack", (*repl).show_stack},
+        {[]string{"locals", "l"}, "Show the variables of the current frame and those outside it", (*repl).show_locals},
+    }
+}
+
+// Sets up the debugger of the interpreter, if it's not yet
+func (r * repl) debugger() *eval.Debugger {
+    if r.it.Debugger == nil {
+        r.it.Debugger = eval.NewDebugger(r.pause)
+    }
+    if r.editor == nil {
+        r.editor = line.NewEditor(history_file())
+    }
+    return r.it.Debugger
+}
+
+// Parses [file:]line
+func parse_break(arg string) (string, int) {
+    file, num := "", arg
+    if i := strings.LastIndexByte(arg, ':'); i >= 0 {
+        file, num = arg[:i], arg[i + 1:]
+    }
+    n, err := strconv.Atoi(num)
+    if err != nil || n <= 0 {
+        panic("Usage: :break [file:]line")
+    }
+    return file, n
+}
+
+func (r * repl) set_break(arg string) {
+    if arg == "" {
+        panic("Usage: :break [file:]line")
+    }
+    r.debugger().Break(parse_break(arg))
+}
+
+func (r * repl) clear_break(arg string) {
+    d := r.debugger()
+    if arg == "" {
+        for _, pos := range d.Breakpoints() {
+            d.Clear(pos.File, pos.Line)
+        }
+        return
+    }
+    d.Clear(parse_break(arg))
+}
+
+func (r * repl) show_breaks(string) {
+    for _, pos := range r.debugger().Breakpoints() {
+        fmt.Println(pos)
+    }
+}
+
+// Evaluates expr stopping at its first form; without expr just turns the
+// debugger on, so that (breakpoint) stops
+func (r * repl) debug(arg string) {
+    d := r.debugger()
+    if arg == "" {
+        return
+    }
+    prog := r.parse_arg(arg)
+    d.SetStep(eval.STEP_IN)
+    defer d.SetStep(eval.STEP_CONTINUE)
+    ret_val := r.eval(prog)
+    fmt.Print(color.Green("returned: "))
+    fmt.Println(ret_val.GoString())
+}
+
+func frame_name(f eval.Frame) string {
+    if f.Name == "" {
+        return "<top>"
+    }
+    return f.Name
+}
+
+func (r * repl) show_stack(s *eval.Stop) (eval.Step, bool) {
+    for i := len(s.Stack) - 1; i >= 0; i -- {
+        f := s.Stack[i]
+        fmt.Printf("#%d %s at %v\n", len(s.Stack) - 1 - i, frame_name(f), f.Pos)
+    }
+    return 0, false
+}
+
+func (r * repl) show_locals(s *eval.Stop) (eval.Step, bool) {
+    for env, depth := s.Env, 0; env != nil && ! env.IsGlobal(); env, depth = env.Outer(), depth + 1 {
+        names, vals := env.Locals()
+        if len(names) == 0 {
+            continue
+        }
+        fmt.Println(color.Yellow(fmt.Sprintf("frame %d:", depth)))
+        for i, name := range names {
+            val := "nil"
+            if vals[i] != nil {
+                val = vals[i].GoString()
+            }
+            fmt.Printf("  %s = %s\n", name, val)
+        }
+    }
+    fmt.Println(color.Yellow("globals:") + " see :env")
+    return 0, false
+}
+
+// Handles a stop of the evaluation: reads commands until one goes on
+func (r * repl) pause(s *eval.Stop) eval.Step {
+    name := "<top>"
+    if len(s.Stack) > 0 {
+        name = frame_name(s.Stack[len(s.Stack) - 1])
+    }
+    fmt.Printf("%s %v in %s: %v\n", color.Red("stopped"), s.Node.Pos, name, s.Node)
+    for {
+        text, err := r.editor.ReadLine("debug> ")
+        if err == io.EOF || err == line.ErrInterrupt {
+            return eval.STEP_CONTINUE
+        } else if err != nil {
+            print_err(err)
+            return eval.STEP_CONTINUE
+        }
+        text = strings.TrimSpace(text)
+        if text == "" {
+            continue
+        }
+        if strings.HasPrefix(text, ":") {
+            if step, ok := r.debug_command(text[1:], s); ok {
+                return step
+            }
+            continue
+        }
+        if val, err := s.Eval(text); err != nil {
+            print_err(err)
+        } else {
+            fmt.Println(val.GoString())
+        }
+    }
+}
+
+// Runs a command at the debug> prompt; the others of the REPL work too
+func (r * repl) debug_command(cmdline string, s *eval.Stop) (eval.Step, bool) {
+    name := cmdline
+    if i := strings.IndexAny(cmdline, " \t"); i >= 0 {
+        name = cmdline[:i]
+    }
+    for _, cmd := range debug_commands {
+        for _, n := range cmd.names {
+            if n == name {
+                return cmd.run(r, s)
+            }
+        }
+    }
+    if name == "help" {
+        for _, cmd := range debug_commands {
+            fmt.Printf("%-14s %s\n", ":" + strings.Join(cmd.names, ", :"), cmd.help)
+        }
+        fmt.Println("Anything else is evaluated in the current frame; the other commands are:")
+    }
+    r.command(cmdline)
+    return 0, false
+}
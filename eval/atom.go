@@ -0,0 +1,167 @@
+package eval
+
+import (
+    "fmt"
+    "sync"
+    "sync/atomic"
+)
+
+// Atoms are cells of shared state, safe to use from any goroutine. (swap! a
+// f args...) sets an atom to (f old args...) with compare-and-swap, calling f
+// again if another goroutine changed the atom meanwhile, so f shouldn't have
+// side effects. A validator can reject new values, and watches are called
+// after every change.
+type Atom struct {
+    val         atomic.Value            // *Object
+    lock        sync.Mutex              // For validator and watches
+    validator   *Object
+    watches     map[Object]*Object      // Key -> (fn [key atom old new])
+}
+
+func NewAtom(val *Object) *Atom {
+    a := &Atom{watches: make(map[Object]*Object)}
+    a.val.Store(val)
+    return a
+}
+
+func (a * Atom) Deref() *Object {
+    return a.val.Load().(*Object)
+}
+
+// Panics if the validator rejects val; functions are called from the frame
+// caller, which can be nil (see call())
+func (a * Atom) validate(val *Object, caller *Env) {
+    a.lock.Lock()
+    validator := a.validator
+    a.lock.Unlock()
+    if validator != nil && call(validator, []*Object{val}, caller) == GYSP_NIL {
+        panic(fmt.Sprintf("Invalid value for atom: %v!", val.GoString()))
+    }
+}
+
+// Calls the watches, in the order of their keys, after the change from old
+// to val
+func (a * Atom) notify(self, old, val *Object, caller *Env) {
+    a.lock.Lock()
+    keys := sorted_keys(a.watches)
+    watches := make([]*Object, len(keys))
+    for i, key := range keys {
+        watches[i] = a.watches[key]
+    }
+    a.lock.Unlock()
+    for i, watch := range watches {
+        key := keys[i]
+        call(watch, []*Object{&key, self, old, val}, caller)
+    }
+}
+
+func (a * Atom) Reset(self, val *Object, caller *Env) *Object {
+    a.validate(val, caller)
+    old := a.val.Swap(val).(*Object)
+    a.notify(self, old, val, caller)
+    return val
+}
+
+func (a * Atom) Swap(self, fn *Object, args []*Object, caller *Env) *Object {
+    for {
+        old := a.Deref()
+        val := call(fn, append([]*Object{old}, args...), caller)
+        a.validate(val, caller)
+        if a.val.CompareAndSwap(old, val) {
+            a.notify(self, old, val, caller)
+            return val
+        }
+    }
+}
+
+func atom_of(name string, o *Object) *Atom {
+    if o.typ != OBJECT_ATOM {
+        panic(fmt.Sprintf("%s needs an atom, not a %s!", name, o.typ.String()))
+    }
+    return o.val.(*Atom)
+}
+
+// The key of a watch, which has to be a number, a string, a bool or nil
+func watch_key(name string, key *Object) Object {
+    switch key.typ {
+    case OBJECT_NIL, OBJECT_BOOL, OBJECT_INT, OBJECT_FLOAT, OBJECT_CMPLX, OBJECT_STR:
+        return *key
+    }
+    panic(fmt.Sprintf("%s: a %s cannot be a watch key!", name, key.typ.String()))
+}
+
+func atom_builtins(it *Interpreter) map[string]*Object {
+    return map[string]*Object {
+        "atom": NewPrim(func (args []*Object) *Object {
+            if len(args) != 1 {
+                panic("atom needs a value!")
+            }
+            if it.Config {      // Freezing globals wouldn't stop an atom from changing
+                panic("Atoms cannot be used in config mode!")
+            }
+            return NewObject(OBJECT_ATOM, NewAtom(args[0]))
+        }),
+        "deref": NewPrim(func (args []*Object) *Object {   // The value of an atom or a future
+            if len(args) != 1 {
+                panic("deref needs a value!")
+            }
+            switch args[0].typ {
+            case OBJECT_ATOM:
+                return args[0].val.(*Atom).Deref()
+            case OBJECT_FUTURE:
+                return it.wait(args[0].val.(*Future))
+            }
+            panic(fmt.Sprintf("Cannot deref a %s!", args[0].typ.String()))
+        }),
+        "reset!": new_caller(func (args []*Object, env *Env) *Object { // (reset! a val)
+            if len(args) != 2 {
+                panic("reset! needs an atom and a value!")
+            }
+            return atom_of("reset!", args[0]).Reset(args[0], args[1], env)
+        }),
+        "swap!": new_caller(func (args []*Object, env *Env) *Object {  // (swap! a f args...)
+            if len(args) < 2 {
+                panic("swap! needs an atom and a function!")
+            }
+            return atom_of("swap!", args[0]).Swap(args[0], args[1], args[2:], env)
+        }),
+        "set-validator!": new_caller(func (args []*Object, env *Env) *Object { // (set-validator! a f); nil removes it
+            if len(args) != 2 {
+                panic("set-validator! needs an atom and a function!")
+            }
+            a := atom_of("set-validator!", args[0])
+            validator := args[1]
+            if validator == GYSP_NIL {
+                validator = nil
+            } else if call(validator, []*Object{a.Deref()}, env) == GYSP_NIL {
+                panic(fmt.Sprintf("Invalid value for atom: %v!", a.Deref().GoString()))
+            }
+            a.lock.Lock()
+            a.validator = validator
+            a.lock.Unlock()
+            return GYSP_NIL
+        }),
+        "add-watch": NewPrim(func (args []*Object) *Object {   // (add-watch a key (fn [key a old new] ...))
+            if len(args) != 3 {
+                panic("add-watch needs an atom, a key and a function!")
+            }
+            a := atom_of("add-watch", args[0])
+            key := watch_key("add-watch", args[1])
+            a.lock.Lock()
+            a.watches[key] = args[2]
+            a.lock.Unlock()
+            return GYSP_NIL
+        }),
+        "remove-watch": NewPrim(func (args []*Object) *Object {
+            if len(args) != 2 {
+                panic("remove-watch needs an atom and a key!")
+            }
+            a := atom_of("remove-watch", args[0])
+            key := watch_key("remove-watch", args[1])
+            a.lock.Lock()
+            delete(a.watches, key)
+            a.lock.Unlock()
+            return GYSP_NIL
+        }),
+    }
+}
@@ -0,0 +1,64 @@
+package eval
+
+import (
+    "sync"
+    "testing"
+)
+
+func TestSwapConcurrent(t *testing.T) {
+    it := NewInterpreter()
+    check_evals(t, it, []eval_test{
+        {`(set hits (atom 0) done (chan))`, "<channel>"},
+        {`(defn work [] (for [i (range 100)] (swap! hits + 1)) (send done 1))`, "<function>..."},
+        {`(do (for [i (range 10)] (go work)) (for [i (range 10)] (recv done)) (deref hits))`, "1000"},
+    })
+
+    // From goroutines of the host too
+    var wg sync.WaitGroup
+    for i := 0; i < 50; i ++ {
+        wg.Add(1)
+        go func () {
+            defer wg.Done()
+            for j := 0; j < 20; j ++ {
+                if _, err := it.Eval(`(swap! hits + 1)`); err != nil {
+                    t.Error(err)
+                    return
+                }
+            }
+        }()
+    }
+    wg.Wait()
+    check_evals(t, it, []eval_test{{`(deref hits)`, "2000"}})
+}
+
+func TestValidator(t *testing.T) {
+    it := limited(Limits{})
+    check_evals(t, it, []eval_test{
+        {`(set a (atom 1))`, "<atom>..."},
+        {`(set-validator! a pos?)`, "nil"},
+        {`(reset! a 2)`, "2"},
+        {`(reset! a -1)`, "error: Invalid value for atom: -1!"},
+        {`(swap! a - 5)`, "error: Invalid value for atom: -3!"},
+        {`(deref a)`, "2"},         // Unchanged
+        {`(try (reset! a 0) (catch e e))`, `"Invalid value for atom: 0!"`},
+        {`(set-validator! (atom 0) pos?)`, "error: Invalid value for atom: 0!"},
+        {`(set-validator! a nil)`, "nil"},
+        {`(reset! a -1)`, "-1"},
+    })
+}
+
+func TestWatchKeys(t *testing.T) {
+    it := NewInterpreter()
+    check_evals(t, it, []eval_test{
+        {`(set a (atom 1) log (atom ""))`, "<atom>..."},
+        {`(add-watch a 2 (fn [k a old new] (swap! log + "2")))`, "nil"},
+        {`(add-watch a "b" (fn [k a old new] (swap! log + "b")))`, "nil"},
+        {`(add-watch a 1 (fn [k a old new] (swap! log + "1")))`, "nil"},
+        {`(do (reset! a 5) (deref log))`, `"12b"`},     // In the order of their keys
+        {`(remove-watch a 2)`, "nil"},
+        {`(do (reset! a 6) (deref log))`, `"12b1b"`},
+        {`(add-watch a [1] (fn [k a old new] nil))`, "error: add-watch: a list cannot be a watch key!"},
+        {`(add-watch a {"k" 1} (fn [k a old new] nil))`, "error: add-watch: a dict cannot be a watch key!"},
+        {`(remove-watch a [1])`, "error: remove-watch: a list cannot be a watch key!"},
+    })
+}
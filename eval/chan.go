@@ -0,0 +1,242 @@
+package eval
+
+import (
+    "context"
+    "fmt"
+    "reflect"
+    "time"
+    "github.com/crides/gysp/parse"
+)
+
+// Goroutines and channels. (go f args...) calls f in a new goroutine, which
+// belongs to the evaluation that started it: its context and limits apply,
+// going over a limit stops the whole evaluation, and other errors ending it
+// are reported on Stderr. The evaluation doesn't wait for its goroutines;
+// once it ends, they're stopped when they next wait on a channel. They can't
+// be used in config mode, where select would make the result random.
+
+func chan_of(o *Object) chan *Object {
+    if o.typ != OBJECT_CHAN {
+        panic(fmt.Sprintf("%s object is not a channel!", o.typ.String()))
+    }
+    return o.val.(chan *Object)
+}
+
+// A number of seconds as a duration
+func seconds(o *Object) time.Duration {
+    switch o.typ {
+    case OBJECT_INT, OBJECT_FLOAT:
+        return time.Duration(to_float(o).val.(float64) * float64(time.Second))
+    }
+    panic("Timeout must be a number of seconds!")
+}
+
+// Panics in config mode
+func (it * Interpreter) check_chans() {
+    if it != nil && it.Config {
+        panic("Goroutines and channels cannot be used in config mode!")
+    }
+}
+
+// Reports the error that ended a goroutine, as there's no one to return it
+// to; going over a limit stops its evaluation with stop
+func (it * Interpreter) report(stop context.CancelCauseFunc) {
+    if e := recover(); e != nil {
+        if err, ok := e.(*LimitError); ok {
+            stop(err)       // Does nothing once the evaluation has ended
+            return
+        }
+        it.io_lock.Lock()
+        defer it.io_lock.Unlock()
+        fmt.Fprintf(it.Stderr, "gysp: goroutine: %v\n", e)
+    }
+}
+
+func chan_builtins(it *Interpreter) map[string]*Object {
+    return map[string]*Object {
+        "go": new_caller(func (args []*Object, env *Env) *Object {     // (go f args...)
+            if len(args) < 1 {
+                panic("go needs a function!")
+            }
+            it.check_chans()
+            _, stop := it.evaluation()
+            fun, fun_args := args[0], append([]*Object(nil), args[1:]...)      // args may be a view of the VM stack
+            go func() {
+                defer it.report(stop)
+                call(fun, fun_args, env)
+            }()
+            return GYSP_NIL
+        }),
+        "chan": NewPrim(func (args []*Object) *Object {    // (chan) or (chan size)
+            it.check_chans()
+            size := 0
+            switch len(args) {
+            case 0:
+            case 1:
+                if args[0].typ != OBJECT_INT || args[0].val.(int) < 0 {
+                    panic("Channel size must be a non-negative int!")
+                }
+                size = args[0].val.(int)
+                it.check_items(size)
+            default:
+                panic("chan takes at most one argument!")
+            }
+            return NewObject(OBJECT_CHAN, make(chan *Object, size))
+        }),
+        "select": select_macro(new_caller(it.do_select)),
+        "send": NewPrim(func (args []*Object) *Object {
+            if len(args) != 2 {
+                panic("send needs a channel and a value!")
+            }
+            ch, ctx := chan_of(args[0]), it.context()
+            select {
+            case ch <- args[1]:
+            case <-ctx.Done():
+                panic(stopped(ctx))
+            }
+            return GYSP_NIL
+        }),
+        "recv": NewPrim(func (args []*Object) *Object {    // nil if the channel is closed
+            if len(args) != 1 {
+                panic("recv needs a channel!")
+            }
+            ch, ctx := chan_of(args[0]), it.context()
+            select {
+            case val, ok := <-ch:
+                if ! ok {
+                    return GYSP_NIL
+                }
+                return val
+            case <-ctx.Done():
+                panic(stopped(ctx))
+            }
+        }),
+        "close": NewPrim(func (args []*Object) *Object {
+            if len(args) != 1 {
+                panic("close needs a channel!")
+            }
+            close(chan_of(args[0]))
+            return GYSP_NIL
+        }),
+    }
+}
+
+// The name of a symbol, resolved to a local or not
+func sym_of(node parse.Node) (parse.Sym, bool) {
+    switch n := node.(type) {
+    case *parse.SymNode:
+        return n.Sym, true
+    case *LocalNode:
+        return n.Sym, true
+    }
+    return 0, false
+}
+
+// The clause of a select and its kind: recv, send, timeout or default; ""
+// if node isn't one
+func select_clause(node parse.Node) (*parse.CallNode, string) {
+    if clause, ok := node.(*parse.CallNode); ok {
+        if sym, ok := sym_of(clause.Fun); ok {
+            switch name := sym.Name(); name {
+            case "recv", "send", "timeout", "default":
+                return clause, name
+            }
+        }
+    }
+    return nil, ""
+}
+
+// (select clause...) waits for the first clause that's ready (a random one
+// if several are) and evaluates its body. The clauses are
+//     (recv ch x body...)      Receives from ch into x (nil if ch is closed)
+//     (send ch val body...)    Sends val on ch
+//     (timeout secs body...)   After secs seconds
+//     (default body...)        If no other clause is ready
+// It's a macro rather than a special form so that select is still a name
+// like any other; it expands to a call of do_select with the kind, channel,
+// value and body (as a function) of each clause.
+func select_macro(do_select *Object) *Object {
+    nil_node := WrapObject(GYSP_NIL)
+    return NewMacro(func (args []parse.Node, env *Env) parse.Node {
+        call_args := make([]parse.Node, 0, len(args) * 4)
+        defaults := 0
+        for _, arg := range args {
+            clause, kind := select_clause(arg)
+            if clause == nil {
+                panic("select clauses must be recv, send, timeout or default!")
+            }
+            a := clause.Arglist
+            var ch, val parse.Node = nil_node, nil_node
+            params := &parse.ListNode{List: []parse.Node{}}
+            switch kind {
+            case "recv":
+                x, ok := parse.Sym(0), len(a) >= 2
+                if ok {
+                    x, ok = sym_of(a[1])
+                }
+                if ! ok {
+                    panic("recv clause needs a channel and a variable!")
+                }
+                ch, params.List, a = a[0], []parse.Node{parse.NewSymNode(x.Name())}, a[2:]
+            case "send":
+                if len(a) < 2 {
+                    panic("send clause needs a channel and a value!")
+                }
+                ch, val, a = a[0], a[1], a[2:]
+            case "timeout":
+                if len(a) < 1 {
+                    panic("timeout clause needs a number of seconds!")
+                }
+                val, a = a[0], a[1:]
+            case "default":
+                if defaults ++; defaults > 1 {
+                    panic("select can only have one default clause!")
+                }
+            }
+            if len(a) == 0 {
+                a = []parse.Node{nil_node}
+            }
+            body := &parse.CallNode{Fun: parse.NewSymNode("fn"), Arglist: append([]parse.Node{params}, a...)}
+            call_args = append(call_args, WrapObject(NewStr(kind)), ch, val, body)
+        }
+        return &parse.CallNode{Fun: WrapObject(do_select), Arglist: call_args}
+    })
+}
+
+// Waits for one of the clauses given by the select macro, and calls its body
+func (it * Interpreter) do_select(args []*Object, env *Env) *Object {
+    it.check_chans()
+    cases := make([]reflect.SelectCase, 0, len(args) / 4 + 1)
+    for i := 0; i < len(args); i += 4 {
+        var c reflect.SelectCase
+        switch args[i].val.(string) {
+        case "recv":
+            c = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(chan_of(args[i + 1]))}
+        case "send":
+            c = reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(chan_of(args[i + 1])), Send: reflect.ValueOf(args[i + 2])}
+        case "timeout":
+            timer := time.NewTimer(seconds(args[i + 2]))
+            defer timer.Stop()
+            c = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timer.C)}
+        case "default":
+            c = reflect.SelectCase{Dir: reflect.SelectDefault}
+        }
+        cases = append(cases, c)
+    }
+    ctx := it.context()         // Stop waiting when the evaluation is stopped
+    cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+
+    chosen, val, ok := reflect.Select(cases)
+    if chosen == len(cases) - 1 {
+        panic(stopped(ctx))
+    }
+    kind, body := args[chosen * 4].val.(string), args[chosen * 4 + 3]
+    if kind == "recv" {
+        x := GYSP_NIL
+        if ok {
+            x = val.Interface().(*Object)
+        }
+        return call(body, []*Object{x}, env)
+    }
+    return call(body, nil, env)
+}
@@ -0,0 +1,411 @@
+package eval
+
+import (
+    "fmt"
+    "strings"
+    "github.com/crides/gysp/parse"
+)
+
+// Tree of Node --- Compile --> Code --- Run --> Objects
+//
+// The compiler turns the special forms `if', `let', `for' and `do' into jumps
+// and scope instructions, and literals into constants, so that the VM doesn't
+// have to dispatch them and allocate literals every time. The bodies of `fn'
+// and `defn' are compiled too, so that the functions they make run on the VM
+// when called. Everything else it doesn't know about (including the other
+// special forms) is left to eval().
+
+type Opcode byte
+
+const (
+    OP_CONST    Opcode = iota   // Push consts[arg]
+    OP_NIL                      // Push nil
+    OP_LOAD                     // Push the global variable names[arg]
+    OP_LOCAL                    // Push the local variable locals[arg]
+    OP_POP                      // Drop the top
+    OP_LIST                     // Pop arg items and push them as a list
+    OP_DICT                     // Pop arg key/value pairs and push them as a dict
+    OP_MACRO                    // If the top is a macro, expand calls[arg] with it
+    OP_CALL                     // Call the function under the arg arguments
+    OP_JUMP                     // Go to arg
+    OP_JUMP_NIL                 // Pop; go to arg if it's nil
+    OP_FRAME                    // Enter a new frame, popping the values of frames[arg]
+    OP_LEAVE                    // Go back to the outer frame
+    OP_FOR                      // Pop the lists of loops[arg], start the loop and enter its frame
+    OP_NEXT                     // Bind the next loop variables, or end the loop and go to arg
+    OP_EVAL                     // Push the value of nodes[arg] from eval()
+    OP_FUNC                     // Push funcs[arg] closed over the current frame
+    OP_SET                      // Set refs[arg] to the top, leaving it
+)
+
+var OPCODE_NAMES = []string{
+    "CONST", "NIL", "LOAD", "LOCAL", "POP", "LIST", "DICT", "MACRO", "CALL",
+    "JUMP", "JUMP_NIL", "FRAME", "LEAVE", "FOR", "NEXT", "EVAL", "FUNC", "SET",
+}
+
+func (op Opcode) String() string {
+    return OPCODE_NAMES[op]
+}
+
+type Instr struct {
+    op      Opcode
+    arg     int32
+}
+
+// A call whose head may turn out to be a macro at run time
+type call_site struct {
+    node    *parse.CallNode
+    end     int             // Where to continue after expanding the macro
+}
+
+type Code struct {
+    instrs  []Instr
+    consts  []*Object
+    names   []parse.Sym
+    locals  []*LocalNode
+    frames  []*layout       // Variables bound when entering frames
+    calls   []call_site
+    loops   []*layout       // Loop variables of `for's
+    nodes   []parse.Node    // Nodes left to eval()
+    funcs   []*Func         // Functions made by fn and defn, without their environment
+    refs    []parse.Node    // References set by set and defn
+    params  *layout         // Parameters, for the body of a function
+    size    int             // Most items on the stack at once
+    height  int             // Items on the stack after the last instruction
+}
+
+// Compiles a program (as returned by parse.Parse) to bytecode to be run in
+// the global environment
+func Compile(node parse.Node) *Code {
+    if _, ok := node.(*parse.ListNode); ! ok {
+        panic("Internal: argument to Compile() is not a ListNode!")
+    }
+
+    c := new(Code)
+    c.body(resolve(node, nil).(*parse.ListNode).List)
+    return c
+}
+
+func (c * Code) emit(op Opcode, arg int) int {
+    c.instrs = append(c.instrs, Instr{op, int32(arg)})
+    switch op {     // Keep track of the stack, to know how big it gets
+    case OP_CONST, OP_NIL, OP_LOAD, OP_LOCAL, OP_EVAL, OP_FUNC:
+        c.height ++
+    case OP_POP, OP_JUMP_NIL:
+        c.height --
+    case OP_LIST:
+        c.height += 1 - arg
+    case OP_DICT:
+        c.height += 1 - 2 * arg
+    case OP_CALL:
+        c.height -= arg
+    case OP_FRAME:
+        c.height -= len(c.frames[arg].slots)
+    case OP_FOR:
+        c.height -= len(c.loops[arg].slots)
+    }
+    if c.height > c.size {
+        c.size = c.height
+    }
+    return len(c.instrs) - 1
+}
+
+// Points the jump at ind to the next instruction
+func (c * Code) patch(ind int) {
+    c.instrs[ind].arg = int32(len(c.instrs))
+}
+
+func (c * Code) name(sym parse.Sym) int {
+    for i, n := range c.names {
+        if n == sym {
+            return i
+        }
+    }
+    c.names = append(c.names, sym)
+    return len(c.names) - 1
+}
+
+func (c * Code) frame(syms []parse.Sym) int {
+    c.frames = append(c.frames, new_layout(syms))
+    return len(c.frames) - 1
+}
+
+func (c * Code) constant(o *Object) int {
+    c.consts = append(c.consts, o)
+    return len(c.consts) - 1
+}
+
+func (c * Code) fallback(node parse.Node) {
+    c.nodes = append(c.nodes, node)
+    c.emit(OP_EVAL, len(c.nodes) - 1)
+}
+
+// Compiles nodes, keeping only the value of the last one
+func (c * Code) body(nodes []parse.Node) {
+    if len(nodes) == 0 {
+        c.emit(OP_NIL, 0)
+        return
+    }
+    for i, node := range nodes {
+        if i > 0 {
+            c.emit(OP_POP, 0)
+        }
+        c.compile(node)
+    }
+}
+
+func (c * Code) compile(node parse.Node) {
+    switch n := node.(type) {
+    case *parse.LiteralNode:
+        c.emit(OP_CONST, c.constant(literal(n)))
+    case *WrapNode:
+        c.emit(OP_CONST, c.constant(n.Val))
+    case *LocalNode:
+        c.locals = append(c.locals, n)
+        c.emit(OP_LOCAL, len(c.locals) - 1)
+    case *parse.ListNode:
+        for _, item := range n.List {
+            c.compile(item)
+        }
+        c.emit(OP_LIST, len(n.List))
+    case *parse.DictNode:
+        for _, k := range n.Keys {
+            c.compile(k)
+            c.compile(n.Dict[k])
+        }
+        c.emit(OP_DICT, len(n.Dict))
+    case *parse.SymNode:
+        if n.Name == "/" || !strings.Contains(n.Name, "/") && !strings.Contains(n.Name, ".") {
+            c.emit(OP_LOAD, c.name(n.Sym))
+        } else {
+            c.fallback(n)
+        }
+    case *parse.CallNode:
+        if sym, ok := n.Fun.(*parse.SymNode); ok {
+            if c.compile_special(sym.Name, n.Arglist) {
+                return
+            }
+            // The other special forms and member calls are left to eval(),
+            // and so are malformed ones, so that the error is raised when
+            // (and if) they are run
+            if special(sym.Sym) != nil || is_member(sym.Name) {
+                c.fallback(n)
+                return
+            }
+        }
+        c.compile(n.Fun)
+        c.calls = append(c.calls, call_site{n, 0})
+        site := len(c.calls) - 1
+        c.emit(OP_MACRO, site)
+        for _, arg := range n.Arglist {
+            c.compile(arg)
+        }
+        c.emit(OP_CALL, len(n.Arglist))
+        c.calls[site].end = len(c.instrs)
+    default:
+        c.fallback(node)
+    }
+}
+
+// Compiles the special form name; returns false if it isn't one, or if args
+// aren't right for it
+func (c * Code) compile_special(name string, args []parse.Node) bool {
+    switch name {
+    case "if":
+        return c.compile_if(args)
+    case "let":
+        return c.compile_let(args)
+    case "for":
+        return c.compile_for(args)
+    case "do":
+        return c.compile_do(args)
+    case "fn":
+        return c.compile_fn("", args)
+    case "defn":
+        return c.compile_defn(args)
+    case "set":
+        return c.compile_set(args)
+    }
+    return false
+}
+
+func (c * Code) compile_if(args []parse.Node) bool {
+    if len(args) < 2 {
+        return false
+    }
+    c.compile(args[0])
+    to_else := c.emit(OP_JUMP_NIL, 0)
+    c.compile(args[1])
+    to_end := c.emit(OP_JUMP, 0)
+    c.height --         // The else branch starts without the value
+    c.patch(to_else)
+    if len(args) > 2 {
+        c.compile(args[2])
+    } else {
+        c.emit(OP_NIL, 0)
+    }
+    c.patch(to_end)
+    return true
+}
+
+// The variable names and value nodes of a binding list like [a 1 b 2]; ok is
+// false if it isn't one
+func bindings(node parse.Node) (names []parse.Sym, vals []parse.Node, ok bool) {
+    list, ok := node.(*parse.ListNode)
+    if ! ok || len(list.List) % 2 != 0 {
+        return nil, nil, false
+    }
+    for i := 0; i < len(list.List); i += 2 {
+        sym, ok := list.List[i].(*parse.SymNode)
+        if ! ok {
+            return nil, nil, false
+        }
+        names = append(names, sym.Sym)
+        vals = append(vals, list.List[i + 1])
+    }
+    return names, vals, true
+}
+
+func (c * Code) compile_let(args []parse.Node) bool {
+    if len(args) < 1 {
+        return false
+    }
+    names, vals, ok := bindings(args[0])
+    if ! ok {
+        return false
+    }
+    for _, val := range vals {      // Values are evaluated outside
+        c.compile(val)
+    }
+    c.emit(OP_FRAME, c.frame(names))
+    c.body(args[1:])
+    c.emit(OP_LEAVE, 0)
+    return true
+}
+
+func (c * Code) compile_for(args []parse.Node) bool {
+    if len(args) < 1 {
+        return false
+    }
+    names, lists, ok := bindings(args[0])
+    if ! ok {
+        return false
+    }
+    for _, list := range lists {
+        c.compile(list)
+    }
+    c.loops = append(c.loops, new_layout(names))
+    c.emit(OP_FOR, len(c.loops) - 1)
+    start := c.emit(OP_NEXT, 0)
+    for _, arg := range args[1:] {
+        c.compile(arg)
+        c.emit(OP_POP, 0)
+    }
+    c.emit(OP_JUMP, start)
+    c.patch(start)
+    c.emit(OP_LEAVE, 0)
+    c.emit(OP_NIL, 0)
+    return true
+}
+
+func (c * Code) compile_do(args []parse.Node) bool {
+    if len(args) < 1 {
+        return false
+    }
+    c.emit(OP_FRAME, c.frame(nil))
+    c.body(args)
+    c.emit(OP_LEAVE, 0)
+    return true
+}
+
+// Compiles the parameter list and body of a fn to a function made at run time
+func (c * Code) compile_fn(name string, args []parse.Node) bool {
+    if len(args) < 2 || sym_list(args[0]) == nil {
+        return false
+    }
+    vars := sym_list(args[0])
+    body := &Code{params: new_layout(vars)}
+    body.body(args[1:])
+    c.funcs = append(c.funcs, &Func{name, vars, nil, args[1:], body})
+    c.emit(OP_FUNC, len(c.funcs) - 1)
+    return true
+}
+
+func (c * Code) compile_defn(args []parse.Node) bool {
+    if len(args) < 1 {
+        return false
+    }
+    name := ""
+    switch ref := args[0].(type) {
+    case *parse.SymNode:
+        name = ref.Name
+    case *LocalNode:
+        name = ref.Sym.Name()
+    default:
+        return false
+    }
+    if ! c.compile_fn(name, args[1:]) {
+        return false
+    }
+    c.refs = append(c.refs, args[0])
+    c.emit(OP_SET, len(c.refs) - 1)
+    return true
+}
+
+func (c * Code) compile_set(args []parse.Node) bool {
+    if len(args) % 2 != 0 {
+        return false
+    }
+    if len(args) == 0 {
+        c.emit(OP_NIL, 0)
+    }
+    for i := 0; i < len(args); i += 2 {
+        if i > 0 {
+            c.emit(OP_POP, 0)
+        }
+        c.compile(args[i + 1])
+        c.refs = append(c.refs, args[i])
+        c.emit(OP_SET, len(c.refs) - 1)
+    }
+    return true
+}
+
+func sym_names(syms []parse.Sym) []string {
+    names := make([]string, len(syms))
+    for i, sym := range syms {
+        names[i] = sym.Name()
+    }
+    return names
+}
+
+// Disassembles the code
+func (c * Code) String() string {
+    lines := make([]string, len(c.instrs))
+    for i, ins := range c.instrs {
+        line := fmt.Sprintf("%4d %-9v", i, ins.op)
+        switch ins.op {
+        case OP_CONST:
+            line += fmt.Sprintf(" %d (%s)", ins.arg, c.consts[ins.arg].GoString())
+        case OP_LOAD:
+            line += fmt.Sprintf(" %d (%s)", ins.arg, c.names[ins.arg].Name())
+        case OP_LOCAL:
+            l := c.locals[ins.arg]
+            line += fmt.Sprintf(" %d (%s %d:%d)", ins.arg, l.Sym.Name(), l.Depth, l.Slot)
+        case OP_FRAME:
+            line += fmt.Sprintf(" %d %v", ins.arg, sym_names(c.frames[ins.arg].names))
+        case OP_FOR:
+            line += fmt.Sprintf(" %d %v", ins.arg, sym_names(c.loops[ins.arg].names))
+        case OP_EVAL:
+            line += fmt.Sprintf(" %d (%s)", ins.arg, c.nodes[ins.arg])
+        case OP_FUNC:
+            f := c.funcs[ins.arg]
+            line += fmt.Sprintf(" %d (%s %v)", ins.arg, f.name, sym_names(f.vars))
+        case OP_SET:
+            line += fmt.Sprintf(" %d (%s)", ins.arg, c.refs[ins.arg])
+        case OP_MACRO, OP_LIST, OP_DICT, OP_CALL, OP_JUMP, OP_JUMP_NIL, OP_NEXT:
+            line += fmt.Sprintf(" %d", ins.arg)
+        }
+        lines[i] = line
+    }
+    return strings.Join(lines, "\n")
+}
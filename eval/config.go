@@ -0,0 +1,99 @@
+package eval
+
+import (
+    "fmt"
+    "sort"
+    "github.com/crides/gysp/parse"
+)
+
+// Config mode, for using Gysp as a configuration language like Starlark.
+// Evaluating a config always gives the same result: there's no I/O (so no
+// clock and no randomness), dicts are iterated in the order of their keys,
+// and the globals a file defines are frozen once it's loaded, so that later
+// files can use them but not change them. Lists and dicts can't be changed
+// anyway, and atoms, which can, aren't available; nor are goroutines and
+// channels, which could make the result depend on timing.
+
+// Makes an interpreter in config mode
+func NewConfigInterpreter() *Interpreter {
+    it := NewInterpreter()
+    it.Config = true
+    it.Perms = NoIO()
+    return it
+}
+
+// Evaluates the file at path in a config interpreter, and decodes its global
+// named name into the value v points to (see Unmarshal())
+func LoadConfig(path, name string, v interface{}) error {
+    it := NewConfigInterpreter()
+    if _, err := it.EvalFile(path); err != nil {
+        return err
+    }
+    return it.Decode(name, v)
+}
+
+// Decodes the global named name into the value v points to
+func (it * Interpreter) Decode(name string, v interface{}) (err error) {
+    defer catch(&err)
+    return Unmarshal(it.Env.GetVar(name), v)
+}
+
+// Freezes all the globals defined so far
+func (it * Interpreter) freeze() {
+    g := it.Env.global
+    g.lock.Lock()
+    defer g.lock.Unlock()
+    if g.frozen == nil {
+        g.frozen = make(map[parse.Sym]bool)
+    }
+    for sym := range g.vars {
+        g.frozen[sym] = true
+    }
+}
+
+// Panics if the global sym is frozen
+func (it * Interpreter) check_frozen(sym parse.Sym) {
+    if it == nil {
+        return
+    }
+    g := it.Env.global
+    g.lock.RLock()
+    frozen := g.frozen[sym]
+    g.lock.RUnlock()
+    if frozen {
+        panic(fmt.Sprintf("Cannot change frozen variable %s!", sym.Name()))
+    }
+}
+
+// Orders dict keys: by type, and then by value
+func key_less(a, b *Object) bool {
+    if a.typ != b.typ {
+        return a.typ < b.typ
+    }
+    switch a.typ {
+    case OBJECT_BOOL:
+        return ! a.val.(bool) && b.val.(bool)
+    case OBJECT_INT:
+        return a.val.(int) < b.val.(int)
+    case OBJECT_FLOAT:
+        return a.val.(float64) < b.val.(float64)
+    case OBJECT_CMPLX:
+        x, y := a.val.(complex128), b.val.(complex128)
+        return real(x) < real(y) || real(x) == real(y) && imag(x) < imag(y)
+    case OBJECT_STR:
+        return a.val.(string) < b.val.(string)
+    }
+    return a.GoString() < b.GoString()
+}
+
+// The keys of dict in order, so that iterating over them is deterministic
+func sorted_keys(dict map[Object]*Object) []Object {
+    keys := make([]Object, 0, len(dict))
+    for k := range dict {
+        keys = append(keys, k)
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        return key_less(&keys[i], &keys[j])
+    })
+    return keys
+}
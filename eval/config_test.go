@@ -0,0 +1,56 @@
+package eval
+
+import (
+    "io/ioutil"
+    "path/filepath"
+    "testing"
+)
+
+func TestDictOrder(t *testing.T) {
+    for _, vm := range []bool{false, true} {
+        it := NewInterpreter()
+        it.VM = vm
+        log := ""
+        it.Define("note", func (s string) string {     // Keeps what it's given, in order
+            log += s
+            return log
+        })
+        for i := 0; i < 20; i ++ {      // Map order would differ between runs
+            log = ""
+            check_evals(t, it, []eval_test{
+                {`{"a" (note "a") "b" (note "b") "c" (note "c") "d" (note "d")}`, "{a: a, b: ab, c: abc, d: abcd}"},
+                {`{(note "x") 1 (note "y") 2 (note "z") 3}`, "{abcdx: 1, abcdxy: 2, abcdxyz: 3}"},
+            })
+        }
+    }
+}
+
+func TestConfigFrozen(t *testing.T) {
+    it := NewConfigInterpreter()
+    check_evals(t, it, []eval_test{
+        {`(set port 80 port 8080)`, "8080"},     // Not frozen until the program ends
+        {`(set port 1)`, "error: Cannot change frozen variable port!"},
+        {`(let [port 1] port)`, "1"},
+        {`(set addr ["localhost" port])`, `[localhost 8080]`},
+        {`(now)`, "error: Permission denied: clock"},
+        {`(atom 1)`, "error: Atoms cannot be used in config mode!"},
+        {`(go + 1)`, "error: Goroutines and channels cannot be used in config mode!"},
+        {`(chan)`, "error: Goroutines and channels cannot be used in config mode!"},
+        {`(select (default 1))`, "error: Goroutines and channels cannot be used in config mode!"},
+    })
+}
+
+func TestLoadConfig(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "app.gy")
+    code := `(set base 8000) (set config {"host-name" "web" "port" (+ base 80) "tags" ["a" "b"]})`
+    if err := ioutil.WriteFile(path, []byte(code), 0644); err != nil {
+        t.Fatal(err)
+    }
+    var s test_server
+    if err := LoadConfig(path, "config", &s); err != nil {
+        t.Fatal(err)
+    }
+    if s.Name != "web" || s.Port != 8080 || len(s.Tags) != 2 {
+        t.Errorf("Loaded %+v", s)
+    }
+}
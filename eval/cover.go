@@ -0,0 +1,279 @@
+package eval
+
+import (
+    "fmt"
+    "html/template"
+    "io"
+    "io/ioutil"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+    "github.com/crides/gysp/parse"
+)
+
+// Coverage records which lines of the files run had code evaluated. The
+// programs run while it's set are added as they start: a line counts when
+// a form, symbol or literal to be evaluated is on it, and it's run when any
+// of them is evaluated. Like the debugger it follows the tree walker.
+type Coverage struct {
+    lock    sync.Mutex
+    files   map[string]map[int]int      // File -> line -> times code on it was evaluated
+}
+
+func NewCoverage() *Coverage {
+    return &Coverage{files: make(map[string]map[int]int)}
+}
+
+// Adds the lines of the code in node, which haven't been run yet
+func (c * Coverage) add(node parse.Node) {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    c.add_node(node)
+}
+
+func (c * Coverage) add_line(pos parse.Pos) {
+    if pos.File == "" || pos.Line == 0 {    // Not from a file, or made by a macro
+        return
+    }
+    lines := c.files[pos.File]
+    if lines == nil {
+        lines = make(map[int]int)
+        c.files[pos.File] = lines
+    }
+    if _, ok := lines[pos.Line]; ! ok {
+        lines[pos.Line] = 0
+    }
+}
+
+func (c * Coverage) add_nodes(nodes []parse.Node) {
+    for _, node := range nodes {
+        c.add_node(node)
+    }
+}
+
+func (c * Coverage) add_node(node parse.Node) {
+    c.add_line(node_pos(node))
+    switch n := node.(type) {
+    case *parse.ListNode:
+        c.add_nodes(n.List)
+    case *parse.DictNode:
+        for _, k := range n.Keys {
+            c.add_node(k)
+            c.add_node(n.Dict[k])
+        }
+    case *parse.CallNode:
+        c.add_call(n)
+    }
+}
+
+// Adds the parts of a call that are evaluated; the names that special forms
+// bind and quoted data aren't
+func (c * Coverage) add_call(n *parse.CallNode) {
+    sym, ok := n.Fun.(*parse.SymNode)
+    if ! ok || special(sym.Sym) == nil {
+        c.add_node(n.Fun)
+    }
+    name, args := "", n.Arglist
+    if ok {
+        name = sym.Name
+    }
+    switch name {
+    case "quote", "quasiquote":     // Data, not code
+        return
+    case "let", "for":              // The values of the bindings and the body
+        if len(args) == 0 {
+            return
+        }
+        if bindings, ok := args[0].(*parse.ListNode); ok {
+            for i := 1; i < len(bindings.List); i += 2 {
+                c.add_node(bindings.List[i])
+            }
+            args = args[1:]
+        }
+    case "fn":                      // The body
+        if len(args) > 0 {
+            args = args[1:]
+        }
+    case "defn":
+        if len(args) > 1 {
+            args = args[2:]
+        }
+    case "set":                     // The values
+        for i := 1; i < len(args); i += 2 {
+            c.add_node(args[i])
+        }
+        return
+    case "try":                     // The body and the handler
+        body, clause := try_clauses(args)
+        c.add_nodes(body)
+        if clause != nil && len(clause.Arglist) > 0 {
+            c.add_nodes(clause.Arglist[1:])
+        }
+        return
+    case "select":                  // The clauses, without the variables of recv
+        for _, arg := range args {
+            clause, kind := select_clause(arg)
+            switch {
+            case kind == "recv" && len(clause.Arglist) >= 2:
+                c.add_node(clause.Arglist[0])
+                c.add_nodes(clause.Arglist[2:])
+            case clause != nil:
+                c.add_nodes(clause.Arglist)
+            default:
+                c.add_node(arg)
+            }
+        }
+        return
+    }
+    c.add_nodes(args)
+}
+
+// Where node is in the source, if it's known
+func node_pos(node parse.Node) parse.Pos {
+    switch n := node.(type) {
+    case *parse.CallNode:
+        return n.Pos
+    case *parse.ListNode:
+        return n.Pos
+    case *parse.DictNode:
+        return n.Pos
+    case *parse.SymNode:
+        return n.Pos
+    case *parse.LiteralNode:
+        return n.Pos
+    case *LocalNode:
+        return n.Pos
+    case *WrapNode:
+        return n.Pos
+    }
+    return parse.Pos{}
+}
+
+func (c * Coverage) hit(pos parse.Pos) {
+    if pos.File == "" || pos.Line == 0 {
+        return
+    }
+    c.lock.Lock()
+    if lines := c.files[pos.File]; lines != nil {
+        lines[pos.Line] ++
+    }
+    c.lock.Unlock()
+}
+
+// The sorted names of the files run
+func (c * Coverage) Files() []string {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    files := make([]string, 0, len(c.files))
+    for file := range c.files {
+        files = append(files, file)
+    }
+    sort.Strings(files)
+    return files
+}
+
+// The lines of file with forms, and how many times they were evaluated
+func (c * Coverage) Lines(file string) map[int]int {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    lines := make(map[int]int, len(c.files[file]))
+    for line, hits := range c.files[file] {
+        lines[line] = hits
+    }
+    return lines
+}
+
+// The number of lines of file with forms, and of those run
+func (c * Coverage) count(file string) (int, int) {
+    lines := c.Lines(file)
+    run := 0
+    for _, hits := range lines {
+        if hits > 0 {
+            run ++
+        }
+    }
+    return len(lines), run
+}
+
+func percent(run, total int) float64 {
+    if total == 0 {
+        return 100
+    }
+    return 100 * float64(run) / float64(total)
+}
+
+// Writes the lines run of each file, and of all of them
+func (c * Coverage) Summary(w io.Writer) {
+    all, all_run := 0, 0
+    for _, file := range c.Files() {
+        total, run := c.count(file)
+        all, all_run = all + total, all_run + run
+        fmt.Fprintf(w, "%s: %d/%d lines, %.1f%%\n", file, run, total, percent(run, total))
+    }
+    fmt.Fprintf(w, "total: %d/%d lines, %.1f%%\n", all_run, all, percent(all_run, all))
+}
+
+var cover_html = template.Must(template.New("cover").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Gysp coverage</title>
+<style>
+body { font-family: sans-serif; }
+pre { line-height: 1.3; }
+.line { color: #888; }
+.run { background: #cfc; }
+.missed { background: #fcc; }
+</style>
+</head>
+<body>
+{{range .}}<h2>{{.File}}: {{.Run}}/{{.Total}} lines, {{printf "%.1f" .Percent}}%</h2>
+<pre>{{range .Lines}}<span class="{{.Class}}"><span class="line">{{printf "%4d" .Num}}</span> {{.Text}}</span>
+{{end}}</pre>
+{{end}}</body>
+</html>
+`))
+
+type cover_line struct {
+    Num     int
+    Text    string
+    Class   string      // "run", "missed", or "" for lines without forms
+}
+
+type cover_file struct {
+    File        string
+    Run, Total  int
+    Percent     float64
+    Lines       []cover_line
+}
+
+// Writes an HTML page with the source of each file, the lines run and missed
+// highlighted; the files are read again for it, and those that aren't there
+// (like "-" for stdin) are left out
+func (c * Coverage) WriteHTML(w io.Writer) error {
+    files := make([]cover_file, 0)
+    for _, file := range c.Files() {
+        src, err := ioutil.ReadFile(file)
+        if os.IsNotExist(err) {
+            continue
+        } else if err != nil {
+            return err
+        }
+        lines := c.Lines(file)
+        total, run := c.count(file)
+        cf := cover_file{File: file, Run: run, Total: total, Percent: percent(run, total)}
+        for i, text := range strings.Split(strings.TrimSuffix(string(src), "\n"), "\n") {
+            class := ""
+            if hits, ok := lines[i + 1]; ok {
+                class = "missed"
+                if hits > 0 {
+                    class = "run"
+                }
+            }
+            cf.Lines = append(cf.Lines, cover_line{i + 1, text, class})
+        }
+        files = append(files, cf)
+    }
+    return cover_html.Execute(w, files)
+}
@@ -0,0 +1,114 @@
+package eval
+
+import (
+    "bytes"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+const COVER_SRC = `(defn f [x]
+  (if (pos? x)
+    (+ x 1)
+    (- x 1)))
+(f 1)
+(set xs [1
+  2])
+(defn g [x y]
+  (if x
+    y
+    x))
+(g 1 2)
+; Not code
+`
+
+// Runs src from a file with coverage; returns the file's path
+func covered(t *testing.T, it *Interpreter, src string) string {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "f.gy")
+    if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+        t.Fatal(err)
+    }
+    it.Cover = NewCoverage()
+    if _, err := it.EvalFile(path); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+func TestCoverLines(t *testing.T) {
+    it := limited(Limits{})
+    path := covered(t, it, COVER_SRC)
+    // Each symbol, literal and form evaluated on a line counts
+    want := map[int]int{1: 1, 2: 4, 3: 4, 4: 0, 5: 3, 6: 3, 7: 1, 8: 1, 9: 2, 10: 1, 11: 0, 12: 4}
+    if lines := it.Cover.Lines(path); ! reflect.DeepEqual(lines, want) {
+        t.Errorf("Lines %v, want %v", lines, want)
+    }
+    it.Eval(`(f 2)`)        // Not from a file, but it runs the lines of f
+    it.EvalFile(path)
+    if hits := it.Cover.Lines(path)[3]; hits != 12 {
+        t.Errorf("Line 3 run %d times, want 12", hits)
+    }
+
+    var out bytes.Buffer
+    it.Cover.Summary(&out)
+    if want := path + ": 10/12 lines, 83.3%\ntotal: 10/12 lines, 83.3%\n"; out.String() != want {
+        t.Errorf("Summary %q, want %q", out.String(), want)
+    }
+}
+
+func TestCoverHTML(t *testing.T) {
+    it := limited(Limits{})
+    path := covered(t, it, COVER_SRC)
+    gone := filepath.Join(t.TempDir(), "gone.gy")
+    ioutil.WriteFile(gone, []byte("(+ 1 2)\n"), 0644)
+    it.EvalFile(gone)
+    os.Remove(gone)
+
+    var out bytes.Buffer
+    if err := it.Cover.WriteHTML(&out); err != nil {
+        t.Fatal(err)
+    }
+    html := out.String()
+    for _, want := range []string{
+        "<h2>" + path + ": 10/12 lines, 83.3%</h2>",
+        `<span class="run"><span class="line">   3</span>     (&#43; x 1)</span>`,
+        `<span class="missed"><span class="line">   4</span>     (- x 1)))</span>`,
+        `<span class="run"><span class="line">   7</span>   2])</span>`,
+        `<span class="run"><span class="line">  10</span>     y</span>`,
+        `<span class="missed"><span class="line">  11</span>     x))</span>`,
+        `<span class=""><span class="line">  13</span> ; Not code</span>`,
+    } {
+        if ! strings.Contains(html, want) {
+            t.Errorf("No %s in\n%s", want, html)
+        }
+    }
+    if strings.Contains(html, "gone.gy") {
+        t.Errorf("A file that's gone is in\n%s", html)
+    }
+}
+
+// The names bound by special forms aren't evaluated, so they don't count
+func TestCoverForms(t *testing.T) {
+    it := NewInterpreter()
+    path := covered(t, it, `(defn h [a
+         b]
+  (let [c
+        (+ a b)]
+    c))
+(h 1 2)
+(let [ch (chan 1)]
+  (send ch 1)
+  (select (recv ch x
+            x)
+          (default
+            2)))
+`)
+    want := map[int]int{1: 1, 3: 1, 4: 4, 5: 1, 6: 4, 7: 4, 8: 4, 9: 3, 10: 1, 12: 0}
+    if lines := it.Cover.Lines(path); ! reflect.DeepEqual(lines, want) {
+        t.Errorf("Lines %v, want %v", lines, want)
+    }
+}
@@ -0,0 +1,195 @@
+package eval
+
+import (
+    "path/filepath"
+    "strings"
+    "sync"
+    "github.com/crides/gysp/parse"
+)
+
+// The debugger stops an evaluation before a call form at a breakpoint (a
+// line, or a (breakpoint) form) or after a step, and hands it to Pause,
+// which can look at the call stack and the environments and evaluate code
+// before going on. It follows the tree walker: with a debugger set, programs
+// aren't run on the VM. Goroutines stop one at a time, but their calls share
+// one stack, so it's best used on code without them.
+type Debugger struct {
+    Pause   func(s *Stop) Step      // Called when stopped; returns how to go on
+
+    lock    sync.Mutex              // Held while stopped
+    breaks  map[parse.Pos]bool      // Breakpoints; File is "" for any file
+    step    Step
+    target  int                     // The level or depth a step stops at
+    level   int                     // Nesting of the call forms being evaluated
+    frames  []Frame                 // The call stack; frames[0] is the top level
+    last    parse.Pos               // Of the last call form, so a breakpoint stops once per line
+}
+
+// How to go on after a stop
+type Step int
+
+const (
+    STEP_CONTINUE   Step = iota     // Until a breakpoint
+    STEP_IN                         // Until the next call form
+    STEP_OVER                       // Until the next call form that's not inside this one
+    STEP_OUT                        // Until the current function returns
+)
+
+// A function being called; Pos is where its evaluation is
+type Frame struct {
+    Name    string      // Of the function; "" for the top level, "fn" for anonymous functions
+    Pos     parse.Pos
+    Env     *Env
+}
+
+// Where an evaluation stopped
+type Stop struct {
+    Node    *parse.CallNode     // The form about to be evaluated
+    Env     *Env
+    Stack   []Frame             // The innermost call last
+    it      *Interpreter
+}
+
+func NewDebugger(pause func(s *Stop) Step) *Debugger {
+    return &Debugger{Pause: pause, breaks: make(map[parse.Pos]bool)}
+}
+
+// Sets how the evaluation goes on, like STEP_IN to stop at the first form
+func (d * Debugger) SetStep(step Step) {
+    d.step, d.target = step, 0
+}
+
+func break_pos(file string, line int) parse.Pos {
+    if file != "" {
+        file = filepath.Clean(file)
+    }
+    return parse.Pos{File: file, Line: line}
+}
+
+// Sets a breakpoint at a line of file; file can be the end of the path of
+// the file run, or "" for any file
+func (d * Debugger) Break(file string, line int) {
+    d.breaks[break_pos(file, line)] = true
+}
+
+func (d * Debugger) Clear(file string, line int) {
+    delete(d.breaks, break_pos(file, line))
+}
+
+func (d * Debugger) Breakpoints() []parse.Pos {
+    list := make([]parse.Pos, 0, len(d.breaks))
+    for pos := range d.breaks {
+        list = append(list, pos)
+    }
+    return list
+}
+
+// Whether there's a breakpoint at pos
+func (d * Debugger) at_break(pos parse.Pos) bool {
+    if pos.Line == 0 || len(d.breaks) == 0 {
+        return false
+    }
+    if d.breaks[parse.Pos{Line: pos.Line}] {     // Any file
+        return true
+    }
+    for bp := range d.breaks {
+        if bp.Line == pos.Line && bp.File != "" &&
+            (pos.File == bp.File || strings.HasSuffix(pos.File, string(filepath.Separator) + bp.File)) {
+            return true
+        }
+    }
+    return false
+}
+
+func (d * Debugger) push(name string, env *Env) {
+    if name == "" {
+        name = "fn"
+    }
+    d.frames = append(d.frames, Frame{name, parse.Pos{}, env})
+}
+
+func (d * Debugger) pop() {
+    d.frames = d.frames[:len(d.frames) - 1]
+}
+
+// Evaluates a call form, stopping before it if it should
+func (d * Debugger) call(n *parse.CallNode, env *Env) *Object {
+    if env.paused {
+        return eval_call(n, env)
+    }
+    if len(d.frames) == 0 {
+        d.frames = append(d.frames, Frame{"", parse.Pos{}, env})
+    }
+    top := &d.frames[len(d.frames) - 1]
+    top.Pos, top.Env = n.Pos, env
+
+    stop := false
+    switch d.step {
+    case STEP_IN:
+        stop = true
+    case STEP_OVER:
+        stop = d.level <= d.target
+    case STEP_OUT:
+        stop = len(d.frames) < d.target
+    }
+    if ! stop && n.Pos != d.last {
+        stop = d.at_break(n.Pos)
+    }
+    if sym, ok := n.Fun.(*parse.SymNode); ok && sym.Name == "breakpoint" {
+        stop = false        // It stops by itself
+    }
+    d.last = n.Pos
+    if stop {
+        d.stop(n, env)
+    }
+
+    d.level ++
+    defer func() { d.level -- }()
+    return eval_call(n, env)
+}
+
+// Hands a stop to Pause, and sets up the step it asks for
+func (d * Debugger) stop(n *parse.CallNode, env *Env) {
+    d.lock.Lock()
+    defer d.lock.Unlock()
+    if d.Pause == nil {
+        return
+    }
+    stack := append([]Frame(nil), d.frames...)
+    d.step = d.Pause(&Stop{n, env, stack, env.it})
+    switch d.step {
+    case STEP_OVER:
+        d.target = d.level
+    case STEP_OUT:
+        d.target = len(d.frames)
+    }
+}
+
+// Evaluates code in the environment of the stop, without stopping in it
+func (s * Stop) Eval(code string) (val *Object, err error) {
+    prog, err := s.it.Parse(code)
+    if err != nil {
+        return nil, err
+    }
+    if len(prog.List) == 0 {
+        return GYSP_NIL, nil
+    }
+    defer catch(&err)
+    env := *s.Env       // Shares the variables, but not the flag
+    env.paused = true
+    return Eval(prog, &env), nil
+}
+
+func debug_builtins(it *Interpreter) map[string]*Object {
+    return map[string]*Object {
+        "breakpoint": new_caller(func (args []*Object, env *Env) *Object {  // Stops here when there's a debugger
+            if len(args) != 0 {
+                panic("breakpoint takes no arguments!")
+            }
+            if d := it.Debugger; d != nil && env != nil && ! env.paused {
+                d.stop(&parse.CallNode{Fun: parse.NewSymNode("breakpoint"), Pos: d.last}, env)
+            }
+            return GYSP_NIL
+        }),
+    }
+}
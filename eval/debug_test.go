@@ -0,0 +1,127 @@
+package eval
+
+import (
+    "fmt"
+    "reflect"
+    "testing"
+)
+
+const DEBUG_PROG = `(defn sq [x]
+  (* x x))
+(defn f [n]
+  (sq n)
+  (sq (+ n 1)))
+(f 3)`
+
+// Runs DEBUG_PROG as the file src/t.gy with a debugger whose Pause returns
+// the steps in turn (then STEP_CONTINUE), and calls at each stop; returns
+// where it stopped, as "line function"
+func debug_run(t *testing.T, start Step, steps []Step, breaks func(d *Debugger), at func(s *Stop)) []string {
+    t.Helper()
+    it := NewInterpreter()
+    stops := []string{}
+    it.Debugger = NewDebugger(func (s *Stop) Step {
+        stops = append(stops, fmt.Sprintf("%d %s", s.Node.Pos.Line, s.Stack[len(s.Stack) - 1].Name))
+        if at != nil {
+            at(s)
+        }
+        if len(steps) == 0 {
+            return STEP_CONTINUE
+        }
+        step := steps[0]
+        steps = steps[1:]
+        return step
+    })
+    it.Debugger.SetStep(start)
+    if breaks != nil {
+        breaks(it.Debugger)
+    }
+    prog, err := it.ParseFile("src/t.gy", DEBUG_PROG)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if val, err := it.Run(prog); err != nil || val.String() != "16" {
+        t.Errorf("Returned %v, %v", val, err)
+    }
+    return stops
+}
+
+func TestDebugSteps(t *testing.T) {
+    for _, test := range []struct {
+        name    string
+        steps   []Step
+        stops   []string
+    }{
+        {"in", []Step{STEP_IN, STEP_IN, STEP_IN, STEP_IN, STEP_IN, STEP_IN, STEP_IN, STEP_IN},
+            []string{"1 ", "3 ", "6 ", "4 f", "2 sq", "5 f", "5 f", "2 sq"}},
+        // Over (sq n), and then over (sq (+ n 1)) to the end
+        {"over", []Step{STEP_OVER, STEP_OVER, STEP_IN, STEP_OVER, STEP_OVER},
+            []string{"1 ", "3 ", "6 ", "4 f", "5 f"}},
+        // Out of the first sq, back in f
+        {"out", []Step{STEP_IN, STEP_IN, STEP_IN, STEP_IN, STEP_OUT},
+            []string{"1 ", "3 ", "6 ", "4 f", "2 sq", "5 f"}},
+    } {
+        if stops := debug_run(t, STEP_IN, test.steps, nil, nil); ! reflect.DeepEqual(stops, test.stops) {
+            t.Errorf("%s: stopped at %q, want %q", test.name, stops, test.stops)
+        }
+    }
+}
+
+func TestDebugBreakpoints(t *testing.T) {
+    stops := debug_run(t, STEP_CONTINUE, nil, func (d *Debugger) {
+        d.Break("t.gy", 2)          // The end of the path
+        d.Break("other.gy", 4)
+        d.Break("", 5)              // Any file; once for the line, not again for (+ n 1)
+    }, nil)
+    if want := []string{"2 sq", "5 f", "2 sq"}; ! reflect.DeepEqual(stops, want) {
+        t.Errorf("Stopped at %q, want %q", stops, want)
+    }
+
+    stops = debug_run(t, STEP_CONTINUE, nil, func (d *Debugger) {
+        d.Break("t.gy", 2)
+        d.Clear("t.gy", 2)
+    }, nil)
+    if len(stops) != 0 {
+        t.Errorf("Stopped at %q after clearing", stops)
+    }
+}
+
+// Stop.Eval sees the paused frame, and doesn't stop at the breakpoints of
+// the code it runs
+func TestStopEval(t *testing.T) {
+    got := []string{}
+    stops := debug_run(t, STEP_CONTINUE, nil, func (d *Debugger) {
+        d.Break("t.gy", 2)
+    }, func (s *Stop) {
+        for _, code := range []string{`x`, `(sq (+ x 1))`, `(f x)`, `(undefined)`} {
+            val, err := s.Eval(code)
+            if err != nil {
+                got = append(got, "error")
+            } else {
+                got = append(got, val.String())
+            }
+        }
+    })
+    if want := []string{"2 sq", "2 sq"}; ! reflect.DeepEqual(stops, want) {
+        t.Errorf("Stopped at %q, want %q", stops, want)
+    }
+    if want := []string{"3", "16", "16", "error", "4", "25", "25", "error"}; ! reflect.DeepEqual(got, want) {
+        t.Errorf("Evaluated to %q, want %q", got, want)
+    }
+}
+
+func TestBreakpointForm(t *testing.T) {
+    it := NewInterpreter()
+    stops := 0
+    it.Debugger = NewDebugger(func (s *Stop) Step {
+        stops ++
+        if val, _ := s.Eval(`i`); val.String() != fmt.Sprint(stops - 1) {
+            t.Errorf("Stop %d with i = %s", stops, val)
+        }
+        return STEP_CONTINUE
+    })
+    check_evals(t, it, []eval_test{{`(for [i (range 3)] (breakpoint) i)`, "nil"}})
+    if stops != 3 {
+        t.Errorf("Stopped %d times", stops)
+    }
+}
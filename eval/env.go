@@ -2,42 +2,264 @@ package eval
 
 import (
     "fmt"
+    "sort"
+    "sync"
+    "github.com/crides/gysp/parse"
 )
 
+// The global environment keeps its variables in a map so that they can be
+// (re)defined at any time, from any goroutine. Every scope inside (let, for,
+// do and function calls) is a frame: a slice of slots whose positions are
+// worked out by Resolve(), with the names kept for lookups by name. Frames
+// aren't locked; like in Go, goroutines sharing a closure's variables must
+// synchronize themselves. Every frame knows the interpreter it belongs to, if
+// any, and the call chain it's in.
 type Env struct {
-	scope   map[string]*Object
-	next    *Env
+    global  *globals                // Only for the global environment
+    names   []parse.Sym             // Names of the slots of a frame
+    slots   []*Object
+    next    *Env
+    it      *Interpreter
+    chain
 }
 
-func NewEnv(outer *Env) *Env {
-    return &Env{make(map[string]*Object), outer}
+// What a frame knows of its call chain, which goes on into the functions it
+// calls, even through primitives and goroutines
+type chain struct {
+    calls   int     // Depth (see Limits.Depth)
+    traced  int     // Nesting of the traced calls (see trace)
+    paused  bool    // Evaluated by Stop.Eval(), so the debugger doesn't stop again
+    prof    *prof_frame     // The innermost call timed by the profiler
+    test    *TestResult     // Of the test running (see deftest)
+    testing []string        // The descriptions of the testing forms around
 }
 
-func (e * Env) NewVar(vname string) {  // Creates a new variable in the current scope
-    e.scope[vname] = nil
+func NewEnv(outer *Env) *Env {     // Creates a frame inside outer
+    if outer == nil {
+        return &Env{}
+    }
+    return &Env{nil, nil, nil, outer, outer.it, outer.chain}
 }
 
-func (e * Env) GetVar(vname string) *Object {
-    for ; e != nil; e = e.next {
-        if item, ok := e.scope[vname]; ok {
-            return item
+// Creates a frame with room for size variables
+func new_frame(outer *Env, size int) *Env {
+    return &Env{nil, make([]parse.Sym, 0, size), make([]*Object, 0, size), outer, outer.it, outer.chain}
+}
+
+// How the names of a let, for or function are bound in its frame: the slot
+// of each name, in the order Resolve() gives them. A name that is given twice
+// has one slot, like with bind().
+type layout struct {
+    names   []parse.Sym     // Of the slots
+    slots   []int           // Slot of each name bound
+}
+
+func new_layout(syms []parse.Sym) *layout {
+    l := &layout{slots: make([]int, len(syms))}
+    for i, sym := range syms {
+        l.slots[i] = len(l.names)
+        for j, name := range l.names {
+            if name == sym {
+                l.slots[i] = j
+                break
+            }
+        }
+        if l.slots[i] == len(l.names) {
+            l.names = append(l.names, sym)
         }
     }
-    panic(fmt.Sprintf("Variable %s not defined!", vname))
+    return l
 }
 
-func (e * Env) SetVar(vname string, val *Object) {
+// Creates a frame inside outer with the names of l bound to vals, straight
+// into their slots
+func (l * layout) frame(outer *Env, vals []*Object) *Env {
+    env, size := new_frame(outer, 0), len(l.names)
+    env.names, env.slots = l.names[:size:size], make([]*Object, size)   // Others are appended to a copy
+    for i, val := range vals {
+        env.slots[l.slots[i]] = val
+    }
+    return env
+}
+
+func NewGlobalEnv() *Env {
+    return &Env{&globals{vars: make(map[parse.Sym]*Object)}, nil, nil, nil, nil, chain{}}
+}
+
+// The variables of the global environment
+type globals struct {
+    lock    sync.RWMutex
+    vars    map[parse.Sym]*Object
+    frozen  map[parse.Sym]bool      // Can't be changed by Gysp code (see config mode)
+}
+
+func (g * globals) lookup(sym parse.Sym) (*Object, bool) {
+    g.lock.RLock()
+    val, ok := g.vars[sym]
+    g.lock.RUnlock()
+    return val, ok
+}
+
+func (g * globals) store(sym parse.Sym, val *Object) {
+    g.lock.Lock()
+    g.vars[sym] = val
+    g.lock.Unlock()
+}
+
+func (g * globals) syms() []parse.Sym {
+    g.lock.RLock()
+    defer g.lock.RUnlock()
+    syms := make([]parse.Sym, 0, len(g.vars))
+    for sym := range g.vars {
+        syms = append(syms, sym)
+    }
+    return syms
+}
+
+// Index of the slot named sym in a frame, or -1
+func (e * Env) slot(sym parse.Sym) int {
+    for i, name := range e.names {
+        if name == sym {
+            return i
+        }
+    }
+    return -1
+}
+
+// Looks up the innermost scope with sym; returns it and the slot in it
+func (e * Env) find(sym parse.Sym) (*Env, int) {
     for ; e != nil; e = e.next {
-        if _, ok := e.scope[vname]; ok {
-            e.scope[vname] = val
-            return
+        if e.global != nil {
+            if _, ok := e.global.lookup(sym); ok {
+                return e, -1
+            }
+        } else if i := e.slot(sym); i >= 0 {
+            return e, i
         }
     }
-    panic(fmt.Sprintf("Variable %s not defined!", vname))
+    return nil, -1
+}
+
+func (e * Env) get(sym parse.Sym) *Object {
+    for ; e != nil; e = e.next {    // Like find(), but looks up a global only once
+        if e.global != nil {
+            if val, ok := e.global.lookup(sym); ok {
+                return val
+            }
+        } else if i := e.slot(sym); i >= 0 {
+            return e.slots[i]
+        }
+    }
+    if special(sym) != nil {
+        panic(fmt.Sprintf("%s is a special form, not a value!", sym.Name()))
+    }
+    panic(fmt.Sprintf("Variable %s not defined!", sym.Name()))
+}
+
+func (e * Env) set(sym parse.Sym, val *Object) {
+    scope, i := e.find(sym)
+    if scope == nil {
+        panic(fmt.Sprintf("Variable %s not defined!", sym.Name()))
+    }
+    if i < 0 {
+        scope.global.store(sym, val)
+    } else {
+        scope.slots[i] = val
+    }
+}
+
+// In the current scope, set the var named sym, creating it if needed
+func (e * Env) bind(sym parse.Sym, val *Object) {
+    check_bindable(sym)
+    if e.global != nil {
+        e.global.store(sym, val)
+    } else if i := e.slot(sym); i >= 0 {
+        e.slots[i] = val
+    } else {
+        e.names = append(e.names, sym)
+        e.slots = append(e.slots, val)
+    }
+}
+
+// Get and set resolved local variables
+func (e * Env) GetLocal(depth, slot int) *Object {
+    for ; depth > 0; depth -- {
+        e = e.next
+    }
+    return e.slots[slot]
+}
+
+func (e * Env) SetLocal(depth, slot int, val *Object) {
+    for ; depth > 0; depth -- {
+        e = e.next
+    }
+    e.slots[slot] = val
+}
+
+func (e * Env) NewVar(vname string) {  // Creates a new variable in the current scope
+    e.bind(parse.Intern(vname), nil)
+}
+
+func (e * Env) GetVar(vname string) *Object {
+    return e.get(parse.Intern(vname))
+}
+
+func (e * Env) SetVar(vname string, val *Object) {
+    e.set(parse.Intern(vname), val)
 }
 
 func (e * Env) SetVarX(vname string, val *Object) {
     // In the current scope, set the var named vname
     // Don't check for variable existence
-    e.scope[vname] = val
+    e.bind(parse.Intern(vname), val)
+}
+
+// The frame outside e, or nil for the global environment
+func (e * Env) Outer() *Env {
+    return e.next
+}
+
+func (e * Env) IsGlobal() bool {
+    return e.global != nil
+}
+
+// Number of frames from e out to the global environment
+func (e * Env) Depth() int {
+    depth := 0
+    for ; e != nil && e.global == nil; e = e.next {
+        depth ++
+    }
+    return depth
+}
+
+// The names and values of the variables of a frame
+func (e * Env) Locals() ([]string, []*Object) {
+    names := make([]string, len(e.names))
+    for i, sym := range e.names {
+        names[i] = sym.Name()
+    }
+    return names, append([]*Object(nil), e.slots...)
+}
+
+func (e * Env) Names() []string {   // Sorted names of all the variables visible from e
+    seen := make(map[parse.Sym]bool)
+    names := make([]string, 0)
+    add := func(sym parse.Sym) {
+        if ! seen[sym] {
+            seen[sym] = true
+            names = append(names, sym.Name())
+        }
+    }
+    for ; e != nil; e = e.next {
+        if e.global != nil {
+            for _, sym := range e.global.syms() {
+                add(sym)
+            }
+        }
+        for _, sym := range e.names {
+            add(sym)
+        }
+    }
+    sort.Strings(names)
+    return names
 }
@@ -9,10 +9,11 @@ import (
 // Moved here because of ``cycle import''
 type WrapNode struct {      // Just wrap a object up
     Val     *Object
+    Pos     parse.Pos       // Of the literal it was made from, if any
 }
 
 func WrapObject(o *Object) *WrapNode {
-    return &WrapNode{o}
+    return &WrapNode{o, parse.Pos{}}
 }
 
 func (wn * WrapNode) NodeTyp() parse.NodeType {
@@ -40,7 +41,7 @@ const (
     OBJECT_DICT     // val: map[Object]*Object
 
     // Functions
-    OBJECT_PRIM     // val: func(...Object) Object
+    OBJECT_PRIM     // val: func(...Object) Object, or with the calling *Env
     OBJECT_MACRO    // val: func(Node) Node; actually a primitive
     OBJECT_FUNC     // val: Func
 
@@ -49,6 +50,11 @@ const (
                     // val: map[string]*Object -> map[var]initializers
     OBJECT_OBJ      // A instance of class
                     // val: map[string]*Object -> map[var]values
+
+    OBJECT_GO       // A Go value from the host; val: interface{}
+    OBJECT_CHAN     // val: chan *Object
+    OBJECT_ATOM     // val: *Atom
+    OBJECT_FUTURE   // val: *Future
 )
 
 func (ot ObjectType) String() string {
@@ -76,9 +82,17 @@ func (ot ObjectType) String() string {
     case OBJECT_FUNC:
         return "function"
     case OBJECT_CLASS:
-        return fmt.Sprintf("<class %s>", ot)
+        return "class"
     case OBJECT_OBJ:
-        return fmt.Sprintf("<%s object>", ot)
+        return "object"
+    case OBJECT_GO:
+        return "go"
+    case OBJECT_CHAN:
+        return "channel"
+    case OBJECT_ATOM:
+        return "atom"
+    case OBJECT_FUTURE:
+        return "future"
     }
     panic(fmt.Sprintf("Unknown type %d!", ot))
 }
@@ -104,6 +118,11 @@ func (o * Object) String() string {
     switch o.typ {
     case OBJECT_STR:
         return o.val.(string)
+    case OBJECT_GO:
+        if err, ok := o.val.(error); ok {   // Like a caught *PermissionError
+            return err.Error()
+        }
+        return o.GoString()
     default:
         return o.GoString()
     }
@@ -126,10 +145,15 @@ func (o * Object) GoString() string {
         return "[" + strings.Join(strs, " ") + "]"
     case OBJECT_DICT:
         strs := make([]string, 0)
-        for key, val := range o.val.(map[Object]*Object) {
-            strs = append(strs, key.String() + ": " + val.String())
+        dict := o.val.(map[Object]*Object)
+        for _, key := range sorted_keys(dict) {
+            strs = append(strs, key.String() + ": " + dict[key].String())
         }
         return "{" + strings.Join(strs, ", ") + "}"
+    case OBJECT_PRIM, OBJECT_MACRO, OBJECT_FUNC, OBJECT_CHAN, OBJECT_ATOM, OBJECT_FUTURE:
+        return "<" + o.typ.String() + ">"
+    case OBJECT_GO:
+        return fmt.Sprintf("<go %T>", o.val)
     }
     return "unknown"
 }
@@ -139,6 +163,7 @@ var (
     GYSP_NIL = NewObject(OBJECT_NIL, nil)
     GYSP_TRUE = NewObject(OBJECT_BOOL, true)
     GYSP_FALSE = NewObject(OBJECT_BOOL, false)
+    GYSP_EMPTY_STR = NewObject(OBJECT_STR, "")
 )
 
 // Some basic object (a class instance) operations
@@ -175,10 +200,99 @@ func (o * Object) Setattr(attr string, val *Object) {
 
 // Gysp function type
 type Func struct {
-    vars    []string
+    name    string      // Set by defn; empty for anonymous functions
+    vars    []parse.Sym
     //rest    bool        // Whether the last argument is variadic
     env     *Env        // The outer environment; for implementing closures
-    body    []*parse.CallNode
+    body    []parse.Node
+    code    *Code       // The body compiled, if it was made by the VM
+}
+
+// Converts a literal to an object; Resolve() does it once for the literals
+// in a program, so that eval() doesn't have to every time
+func literal(n *parse.LiteralNode) *Object {
+    switch v := n.Val.(type) {
+    case int:
+        return NewInt(v)
+    case float64:
+        return NewObject(OBJECT_FLOAT, v)
+    case complex128:
+        return NewObject(OBJECT_CMPLX, v)
+    case string:
+        return NewStr(v)
+    }
+    return GYSP_NIL
+}
+
+// Runs a Gysp function with evaluated arguments in a new frame inside the
+// environment it was defined in, one call deeper than the caller
+func call_func(fun *Func, args []*Object, caller *Env) *Object {
+    vars, body := fun.vars, fun.body
+    if var_len, arg_len := len(vars), len(args); var_len != arg_len {     // Check length of arguments
+        panic(fmt.Sprintf("Expected %d arguments but %d were given", var_len, arg_len))
+    }
+
+    it := fun.env.it
+    if it != nil {
+        it.enter(caller.calls + 1)
+    }
+    var inner_env *Env
+    if fun.code != nil {
+        inner_env = fun.code.params.frame(fun.env, args)
+    } else {
+        inner_env = new_frame(fun.env, len(vars))
+        for i, arg := range args {
+            inner_env.bind(vars[i], arg)        // Create and set variable
+        }
+    }
+    inner_env.chain = caller.chain
+    inner_env.calls ++
+    if it != nil && it.Debugger != nil {        // The debugger follows the tree walker
+        d := it.Debugger
+        d.push(fun.name, inner_env)
+        defer d.pop()
+        return eval_body(body, inner_env)
+    }
+    if fun.code != nil && (it == nil || ! it.tree_walker()) {
+        return Run(fun.code, inner_env)
+    }
+    return eval_body(body, inner_env)
+}
+
+// Calls a primitive or a function with evaluated arguments from the frame
+// caller, whose call chain it continues; without one (like from Go), the
+// chain starts where the function was defined
+func call(_func *Object, args []*Object, caller *Env) *Object {
+    switch _func.typ {
+    case OBJECT_FUNC:
+        if caller == nil {
+            caller = _func.val.(*Func).env
+        }
+        fallthrough
+    case OBJECT_PRIM:
+        if caller != nil && caller.it != nil && caller.it.Profiler != nil {
+            return caller.it.Profiler.call(_func, args, caller)
+        }
+    }
+    return call_direct(_func, args, caller)
+}
+
+// Like call(), without the profiler
+func call_direct(_func *Object, args []*Object, caller *Env) *Object {
+    switch _func.typ {
+    case OBJECT_PRIM:
+        if f, ok := _func.val.(func([]*Object, *Env) *Object); ok {
+            return f(args, caller)
+        }
+        return _func.val.(func([]*Object) *Object)(args)
+    case OBJECT_FUNC:
+        return call_func(_func.val.(*Func), args, caller)
+    }
+    panic(fmt.Sprintf("%s object can't be used as a function!", _func.typ.String()))
+}
+
+func apply(_func *Object, args []*Object) *Object {
+    return call(_func, args, nil)
 }
 
 func EvalList(nodes []parse.Node, env *Env) []*Object {
@@ -197,7 +311,7 @@ func Eval(node parse.Node, env *Env) *Object {
         panic("Internal: argument to Eval() is not a ListNode!")
     }
 
-    prog := _prog.List
+    prog := Resolve(_prog, env).(*parse.ListNode).List
     prog_len := len(prog)
     for i := 0; i < prog_len - 1; i ++ {
         eval(prog[i], env)
@@ -205,89 +319,74 @@ func Eval(node parse.Node, env *Env) *Object {
     return eval(prog[prog_len - 1], env)
 }
 
-var DEFERED_CALL *Object = nil      // A deferred evaluation of function; used for tail-call
-                                    // optimization. Is a CallNode wrapped as a macro
-
-func defer_eval(node parse.Node, env *Env) *Object {
-    switch node.(type) {
-    case *parse.CallNode:
-        DEFERED_CALL = NewMacro(func (args []parse.Node, env *Env) parse.Node {
-            return node
-        })
-        return DEFERED_CALL
-    default:
-        return eval(node, env)
-    }
-}
-
 func eval(node parse.Node, env *Env) *Object {
-//start:      // For argument substitution in tail-call optimization
+    if it := env.it; it != nil {
+        it.step()
+        if it.Cover != nil {
+            it.Cover.hit(node_pos(node))
+        }
+    }
     switch n := node.(type) {
     // Literals
     case *parse.LiteralNode:
-        obj_flag := OBJECT_NIL      // Dummy flag initializer
-        switch n.Val.(type) {
-        case int:
-            obj_flag = OBJECT_INT
-        case float64:
-            obj_flag = OBJECT_FLOAT
-        case complex128:
-            obj_flag = OBJECT_CMPLX
-        case string:
-            obj_flag = OBJECT_STR
-        }
-        return NewObject(obj_flag, n.Val)
+        return literal(n)
     case *WrapNode:         // Unfortunately it's here so no ``parse.''
         return n.Val
     case *parse.ListNode:
         return NewObject(OBJECT_LIST, EvalList(n.List, env))
     case *parse.DictNode:
         dict := make(map[Object]*Object)
-        for k, v := range n.Dict {
-            dict[*eval(k, env)] = eval(v, env)
+        for _, k := range n.Keys {
+            dict[*eval(k, env)] = eval(n.Dict[k], env)
         }
         return NewObject(OBJECT_DICT, dict)
 
     // Variable and references
+    case *LocalNode:
+        return env.GetLocal(n.Depth, n.Slot)
     case *parse.SymNode:
         if n.Name == "/" || !strings.Contains(n.Name, "/") && !strings.Contains(n.Name, ".") {
             // Just a variable; no subs
-            return env.GetVar(n.Name)
+            return env.get(n.Sym)
         }
         panic("Not implemented!")
 
     // Function calls
     case *parse.CallNode:
-        _func := eval(n.Fun, env)       // Not really a function 'cause we don't know its type
-        switch _func.Typ() {
-        case OBJECT_PRIM:
-            return _func.val.(func([]*Object) *Object)(EvalList(n.Arglist, env))
-        case OBJECT_MACRO:
-            return eval(_func.val.(func([]parse.Node, *Env) parse.Node)(n.Arglist, env), env)
-        case OBJECT_FUNC:
-            inner_env := NewEnv(env)
-            fun := _func.val.(*Func)
-            vars, body, args := fun.vars, fun.body, n.Arglist
-            var_len, arg_len := len(vars), len(args)
-
-            if var_len != arg_len {     // Check length of arguments
-                panic(fmt.Sprintf("Expected %d arguments but %d were given", var_len, arg_len))
-            }
-
-            for i := 0; i < var_len; i ++ {
-                inner_env.SetVarX(vars[i], eval(args[i], env))     // Create and set variable
-            }
-
-            // Run function body
-            body_len := len(body)
-            for i := 0; i < body_len - 1; i ++ {
-                eval(fun.body[i], inner_env)
-            }
-            return eval(body[body_len - 1], inner_env)
-            //node = body[body_len - 1]     // Substitude the ``node'' argument
-            //goto start                    // And repeat the function again
+        if it := env.it; it != nil && it.Debugger != nil {
+            return it.Debugger.call(n, env)
         }
-        panic(fmt.Sprintf("%s object can't be used as a function!", _func.Typ().String()))
+        return eval_call(n, env)
     }
     panic("Not implemented!")
 }
+
+// Evaluates a call of a special form, a macro or a function
+func eval_call(n *parse.CallNode, env *Env) *Object {
+    if sym, ok := n.Fun.(*parse.SymNode); ok {
+        if form := special(sym.Sym); form != nil {
+            return form(n.Arglist, env)
+        }
+        if is_member(sym.Name) {
+            return call_member(sym.Name[1:], EvalList(n.Arglist, env), env)
+        }
+    }
+    _func := eval(n.Fun, env)       // Not really a function 'cause we don't know its type
+    switch _func.Typ() {
+    case OBJECT_MACRO:
+        expansion := expand(_func.val.(func([]parse.Node, *Env) parse.Node), n.Arglist, env)
+        if it := env.it; it != nil && it.Hooks != nil {
+            it.Hooks.OnMacroExpand(n, env.Depth(), expansion)
+        }
+        return eval(expansion, env)
+    case OBJECT_FUNC:
+        fun := _func.val.(*Func)
+        if var_len, arg_len := len(fun.vars), len(n.Arglist); var_len != arg_len {
+            panic(fmt.Sprintf("Expected %d arguments but %d were given", var_len, arg_len))
+        }
+    }
+    if it := env.it; it != nil && it.Hooks != nil {
+        return it.hooked_call(n, env, _func, EvalList(n.Arglist, env))
+    }
+    return call(_func, EvalList(n.Arglist, env), env)
+}
@@ -0,0 +1,60 @@
+package eval
+
+import (
+    "strings"
+    "github.com/crides/gysp/parse"
+)
+
+// A Gysp exception, raised (as a panic) by throw or by a Go function
+// returning an error, and caught by try. Its value is what try binds; for a
+// *PermissionError that's the error as a Go object, which prints as its
+// message and has (.op e) and (.what e).
+type Exception struct {
+    Val     *Object
+    Err     error       // The Go error it came from, if any
+}
+
+func (e * Exception) Error() string {
+    return e.Val.String()
+}
+
+func (e * Exception) Unwrap() error {
+    return e.Err
+}
+
+// Raises an error returned by Go code as an exception
+func raise(err error) {
+    panic(&Exception{NewStr(err.Error()), err})
+}
+
+// The exception for a panic raised while evaluating, or nil if it can't be
+// caught. Only what Gysp raises can be: throw, the errors of primitives and
+// the errors returned by Go functions; exit, limits and bugs (like runtime
+// errors, internal errors and other panics of Go code) can't.
+func to_exception(e interface{}) *Exception {
+    switch e := e.(type) {
+    case *Exception:
+        return e
+    case *PermissionError:      // Kept, so that Gysp code can tell it apart
+        return &Exception{NewGo(e), e}
+    case string:
+        if strings.HasPrefix(e, "Internal:") {
+            return nil
+        }
+        return &Exception{NewStr(e), nil}
+    }
+    return nil
+}
+
+// Splits the arguments of a try into the body and the catch clause, if any
+func try_clauses(args []parse.Node) ([]parse.Node, *parse.CallNode) {
+    if len(args) == 0 {
+        return args, nil
+    }
+    if last, ok := args[len(args) - 1].(*parse.CallNode); ok {
+        if sym, ok := last.Fun.(*parse.SymNode); ok && sym.Name == "catch" {
+            return args[:len(args) - 1], last
+        }
+    }
+    return args, nil
+}
@@ -19,6 +19,11 @@ func (g * Generator) AddSource(a []*Object) {
 }
 
 func (g * Generator) Generate(f func([]*Object) *Object) {
+    for _, size := range g.sizes {
+        if size == 0 {      // No combinations at all
+            return
+        }
+    }
     for g.ptrs[0] < g.sizes[0] {
         vals := make([]*Object, g.size)
         for i := 0; i < g.size; i ++ {
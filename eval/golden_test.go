@@ -0,0 +1,86 @@
+package eval
+
+import (
+    "bytes"
+    "flag"
+    "io/ioutil"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "testing"
+)
+
+// The programs in testdata/*.gy are run on the tree walker and on the VM, and
+// their output (with the error that ended them, if any) has to match the
+// golden file next to them, like testdata/arith.golden. A program that should
+// fail says so with a comment like
+//     ; error: not defined
+// and has to fail with an error containing the text. Run with -update to
+// write the golden files from the output of the tree walker.
+
+var update = flag.Bool("update", false, "Write the golden files of testdata")
+
+var EXPECT_ERROR = regexp.MustCompile(`(?m)^;\s*error:\s*(.*?)\s*$`)
+
+// Runs the program in path; returns its output and the error that ended it
+func run_golden(path string, vm bool) (string, error) {
+    var out bytes.Buffer
+    it := NewInterpreter()
+    it.Stdin, it.Stdout, it.Stderr = strings.NewReader(""), &out, &out
+    it.VM = vm
+    _, err := it.EvalFile(path)
+    if err != nil {
+        out.WriteString("error: " + err.Error() + "\n")
+    }
+    return out.String(), err
+}
+
+func TestGolden(t *testing.T) {
+    paths, err := filepath.Glob(filepath.Join("testdata", "*.gy"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(paths) == 0 {
+        t.Fatal("No programs in testdata!")
+    }
+    for _, path := range paths {
+        path := path
+        name := strings.TrimSuffix(filepath.Base(path), ".gy")
+        t.Run(name, func(t *testing.T) {
+            src, err := ioutil.ReadFile(path)
+            if err != nil {
+                t.Fatal(err)
+            }
+            expect_err := ""
+            if m := EXPECT_ERROR.FindSubmatch(src); m != nil {
+                expect_err = string(m[1])
+            }
+
+            golden := strings.TrimSuffix(path, ".gy") + ".golden"
+            out, err := run_golden(path, false)
+            switch {
+            case expect_err == "" && err != nil:
+                t.Errorf("Unexpected error: %v", err)
+            case expect_err != "" && err == nil:
+                t.Errorf("Expected an error containing %q", expect_err)
+            case expect_err != "" && ! strings.Contains(err.Error(), expect_err):
+                t.Errorf("Expected an error containing %q, got %q", expect_err, err.Error())
+            }
+            if *update {
+                if err := ioutil.WriteFile(golden, []byte(out), 0644); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            want, err := ioutil.ReadFile(golden)
+            if err != nil {
+                t.Fatalf("%v (run with -update to write it)", err)
+            }
+            if out != string(want) {
+                t.Errorf("Output of the tree walker differs from %s:\n%s\nwant:\n%s", golden, out, want)
+            }
+            if vm_out, _ := run_golden(path, true); vm_out != string(want) {
+                t.Errorf("Output of the VM differs from %s:\n%s\nwant:\n%s", golden, vm_out, want)
+            }
+        })
+    }
+}
@@ -0,0 +1,61 @@
+package eval
+
+import (
+    "fmt"
+    "reflect"
+)
+
+// Go objects let the host hand live Go values (like database handles) to
+// Gysp code, which can call their exported methods and read their fields
+// with (.name obj args...). Names are in kebab-case, like `(.query-row db q)'
+// for db.QueryRow(q); the Go names work too.
+
+func NewGo(v interface{}) *Object {
+    return NewObject(OBJECT_GO, v)
+}
+
+// Whether name is a member access like .method
+func is_member(name string) bool {
+    return len(name) > 1 && name[0] == '.'
+}
+
+// Whether the Go name goname is called name from Gysp
+func member_matches(goname, name string) bool {
+    return goname == name || KebabCase(goname) == name
+}
+
+// Calls the method name of the Go object args[0] with the rest of args, or
+// gets its field name
+func call_member(name string, args []*Object, env *Env) *Object {
+    if len(args) < 1 {
+        panic(fmt.Sprintf(".%s needs an object!", name))
+    }
+    obj := args[0]
+    if obj.typ != OBJECT_GO {
+        panic(fmt.Sprintf("Cannot get .%s of %v object!", name, obj.typ))
+    }
+
+    v := reflect.ValueOf(obj.val)
+    t := v.Type()
+    for i := 0; i < t.NumMethod(); i ++ {
+        if member_matches(t.Method(i).Name, name) {
+            return apply(wrap_func(v.Method(i), env.it), args[1:])
+        }
+    }
+
+    sv := v
+    if sv.Kind() == reflect.Ptr && ! sv.IsNil() {
+        sv = sv.Elem()
+    }
+    if sv.Kind() == reflect.Struct {
+        for _, field := range struct_fields(sv.Type()) {
+            if member_matches(sv.Type().FieldByIndex(field.index).Name, name) || field.name == name {
+                if len(args) > 1 {
+                    panic(fmt.Sprintf("Field .%s takes no arguments!", name))
+                }
+                return from_go(sv.FieldByIndex(field.index), env.it, false)
+            }
+        }
+    }
+    panic(fmt.Sprintf("Go %v has no method or field '%s'!", t, name))
+}
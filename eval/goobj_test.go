@@ -0,0 +1,99 @@
+package eval
+
+import (
+    "errors"
+    "fmt"
+    "testing"
+)
+
+type test_conn struct {
+    Host    string
+    Port    int
+    queries int
+}
+
+func (c test_conn) Addr() string {      // Value receiver
+    return fmt.Sprintf("%s:%d", c.Host, c.Port)
+}
+
+func (c * test_conn) Query(q string, args ...int) (int, error) {
+    if q == "" {
+        return 0, errors.New("Empty query!")
+    }
+    c.queries ++
+    return len(args), nil
+}
+
+func (c * test_conn) Queries() int {
+    return c.queries
+}
+
+func (c * test_conn) HTTPServer() string {
+    return "server"
+}
+
+func TestCallMember(t *testing.T) {
+    it := NewInterpreter()
+    it.Define("conn", &test_conn{Host: "db", Port: 5432})
+    it.Define("val", NewGo(test_conn{Host: "val", Port: 1}))
+    check_evals(t, it, []eval_test{
+        // Methods through a pointer, with both kinds of receivers
+        {`(.addr conn)`, `"db:5432"`},
+        {`(.Addr conn)`, `"db:5432"`},
+        {`(.query conn "q" 1 2)`, "2"},
+        {`(.query conn "q")`, "0"},
+        {`(.queries conn)`, "2"},       // Changed through the pointer
+        {`(.http-server conn)`, `"server"`},
+        {`(.HTTPServer conn)`, `"server"`},
+        {`(try (.query conn "") (catch e e))`, `"Empty query!"`},
+        {`(.query conn 1)`, "error: Cannot convert int to Go string!"},
+        // Fields
+        {`(.host conn)`, `"db"`},
+        {`(.port conn)`, "5432"},
+        {`(.Port conn)`, "5432"},
+        {`(.host conn 1)`, "error: Field .host takes no arguments!"},
+        {`(.queries-made conn)`, "error: Go *eval.test_conn has no method or field 'queries-made'!"},
+        // A value only has the methods with value receivers
+        {`(.addr val)`, `"val:1"`},
+        {`(.host val)`, `"val"`},
+        {`(.query val "q")`, "error: Go eval.test_conn has no method or field 'query'!"},
+        // Not Go objects
+        {`(.addr 1)`, "error: Cannot get .addr of int object!"},
+        {`(.addr)`, "error: .addr needs an object!"},
+    })
+}
+
+func TestKebabCase(t *testing.T) {
+    for _, test := range []struct{ goname, name string }{
+        {"Addr", "addr"},
+        {"MaxRetries", "max-retries"},
+        {"HTTPServer", "http-server"},
+        {"ServeHTTP", "serve-http"},
+        {"TLSConfig", "tls-config"},
+        {"UserID", "user-id"},
+        {"ID", "id"},
+        {"V2Beta", "v2-beta"},
+        {"getURL", "get-url"},
+        {"X", "x"},
+        {"", ""},
+    } {
+        if got := KebabCase(test.goname); got != test.name {
+            t.Errorf("KebabCase(%q) = %q, want %q", test.goname, got, test.name)
+        }
+    }
+}
+
+func TestCamelCase(t *testing.T) {
+    for _, test := range []struct{ name, goname string }{
+        {"addr", "Addr"},
+        {"max-retries", "MaxRetries"},
+        {"http-server", "HttpServer"},      // Acronyms can't be told apart
+        {"v2-beta", "V2Beta"},
+        {"a--b", "AB"},
+        {"", ""},
+    } {
+        if got := CamelCase(test.name); got != test.goname {
+            t.Errorf("CamelCase(%q) = %q, want %q", test.name, got, test.goname)
+        }
+    }
+}
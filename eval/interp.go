@@ -0,0 +1,202 @@
+package eval
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "reflect"
+    "strings"
+    "sync"
+    "github.com/crides/gysp/parse"
+)
+
+// An interpreter for embedding Gysp. Each one has its own global
+// environment and I/O, so several can run side by side; they only share the
+// constant objects like GYSP_NIL and the small ints, which never change.
+type Interpreter struct {
+    Env     *Env
+    Stdin   io.Reader
+    Stdout  io.Writer
+    Stderr  io.Writer
+    VM      bool            // Run programs on the bytecode VM instead of the tree walker
+    Limits  Limits
+    Perms   Permissions     // What the I/O primitives may do
+    Config  bool            // Freeze the globals after each program (see NewConfigInterpreter())
+    Parallelism int         // Goroutines for pmap, pfilter and preduce; 0 for one per CPU
+    Debugger *Debugger      // Stops evaluations at breakpoints, if set
+    Hooks   Hooks           // Observe the calls of evaluations, if set
+    Profiler *Profiler      // Times the calls of evaluations, if set
+    Cover   *Coverage       // Records the lines run, if set
+
+    lexer   *parse.Lexer
+    lock    sync.Mutex      // For ctx, running, traced and tests
+    ctx     context.Context // Of the running evaluation; passed to Go functions that take one
+    cancel  context.CancelCauseFunc
+    running int             // Nesting of evaluations
+    steps   int64           // Atomic
+    io_lock sync.Mutex      // For Stdin, Stdout and Stderr
+    stdin   *bufio.Reader   // Buffers Stdin for read-line
+    src     io.Reader       // The Stdin stdin was made for
+    traced  map[parse.Sym]traced    // Global functions replaced by trace
+    tests   []*Test         // Defined by deftest
+}
+
+// Makes an interpreter with the built-ins, reading and writing the standard
+// streams; it's allowed all I/O until Perms is changed
+func NewInterpreter() *Interpreter {
+    it := &Interpreter{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr,
+        Perms: AllowAll(), lexer: parse.NewLexer(), ctx: context.Background()}
+    it.Reset()
+    return it
+}
+
+// Starts over with a fresh global environment
+func (it * Interpreter) Reset() {
+    it.Env = NewGlobalEnv()
+    it.Env.it = it
+    it.traced, it.tests = nil, nil
+    for _, defs := range []map[string]*Object{builtins(it), io_builtins(it), chan_builtins(it), atom_builtins(it), parallel_builtins(it), debug_builtins(it), trace_builtins(it), testing_builtins(it)} {
+        for name, val := range defs {
+            it.Env.SetVarX(name, val)
+        }
+    }
+}
+
+// The error for a panic raised while evaluating
+func to_error(e interface{}) error {
+    if err, ok := e.(error); ok {
+        return err
+    }
+    return fmt.Errorf("%v", e)
+}
+
+// Turns a panic raised while evaluating into an error
+func catch(err *error) {
+    if e := recover(); e != nil {
+        *err = to_error(e)
+    }
+}
+
+// Parses code; a leading shebang line is skipped
+func (it * Interpreter) Parse(code string) (*parse.ListNode, error) {
+    return it.ParseFile("", code)
+}
+
+// Parses the code of a file, so that its nodes know where they are
+func (it * Interpreter) ParseFile(file, code string) (prog *parse.ListNode, err error) {
+    defer catch(&err)
+    if strings.HasPrefix(code, "#!") {
+        if i := strings.IndexByte(code, '\n'); i >= 0 {
+            code = code[i:]     // Keep the newline
+        } else {
+            code = ""
+        }
+    }
+    return parse.Parse(it.lexer.LexFile(file, code)).(*parse.ListNode), nil
+}
+
+// Evaluates a parsed program in the global environment; an empty program
+// evaluates to nil
+func (it * Interpreter) Run(prog *parse.ListNode) (*Object, error) {
+    return it.RunContext(context.Background(), prog)
+}
+
+// Like Run(), but stops with a *LimitError when ctx is done
+func (it * Interpreter) RunContext(ctx context.Context, prog *parse.ListNode) (val *Object, err error) {
+    defer catch(&err)
+    defer it.begin(ctx)()
+    if len(prog.List) == 0 {
+        return GYSP_NIL, nil
+    }
+    if it.Cover != nil {
+        it.Cover.add(prog)
+    }
+    if it.VM && ! it.tree_walker() {
+        val = Exec(prog, it.Env)
+    } else {
+        val = Eval(prog, it.Env)
+    }
+    if it.Config && it.running == 1 {
+        it.freeze()
+    }
+    return val, nil
+}
+
+// Whether programs have to run on the tree walker, for the debugger, the hooks
+// or coverage
+func (it * Interpreter) tree_walker() bool {
+    return it.Debugger != nil || it.Hooks != nil || it.Cover != nil
+}
+
+func (it * Interpreter) Eval(code string) (*Object, error) {
+    return it.EvalContext(context.Background(), code)
+}
+
+func (it * Interpreter) EvalContext(ctx context.Context, code string) (*Object, error) {
+    prog, err := it.Parse(code)
+    if err != nil {
+        return nil, err
+    }
+    return it.RunContext(ctx, prog)
+}
+
+func (it * Interpreter) EvalFile(path string) (*Object, error) {
+    code, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    prog, err := it.ParseFile(path, string(code))
+    if err != nil {
+        return nil, err
+    }
+    return it.Run(prog)
+}
+
+// Calls the global function or primitive named name
+func (it * Interpreter) Call(name string, args ...*Object) (*Object, error) {
+    return it.CallContext(context.Background(), name, args...)
+}
+
+func (it * Interpreter) CallContext(ctx context.Context, name string, args ...*Object) (val *Object, err error) {
+    defer catch(&err)
+    defer it.begin(ctx)()
+    return apply(it.Env.GetVar(name), args), nil
+}
+
+// Binds name to val in the global environment. val can be an *Object or a
+// Go value: ints, floats, strings, slices, maps and structs are converted,
+// pointers to structs are kept as Go objects, and functions can be called
+// from Gysp with their arguments converted the same way; a trailing error
+// result is raised as an exception.
+func (it * Interpreter) Define(name string, val interface{}) {
+    it.Env.SetVarX(name, from_go(reflect.ValueOf(val), it, false))
+}
+
+// Prints args to Stdout with print (like fmt.Fprintln); the output of
+// goroutines isn't interleaved
+func (it * Interpreter) print(print func(io.Writer, ...interface{}) (int, error), args []*Object) {
+    converted := make([]interface{}, len(args))
+    for i := 0; i < len(args); i ++ {
+        converted[i] = args[i]
+    }
+    it.io_lock.Lock()
+    defer it.io_lock.Unlock()
+    print(it.Stdout, converted...)
+}
+
+// Reads a line from Stdin without the newline; false at the end of input
+func (it * Interpreter) read_line() (string, bool) {
+    it.io_lock.Lock()
+    defer it.io_lock.Unlock()
+    if it.stdin == nil || it.src != it.Stdin {
+        it.stdin, it.src = bufio.NewReader(it.Stdin), it.Stdin
+    }
+    line, err := it.stdin.ReadString('\n')
+    if err != nil && line == "" {
+        return "", false
+    }
+    return strings.TrimSuffix(line, "\n"), true
+}
@@ -0,0 +1,204 @@
+package eval
+
+import (
+    "bytes"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// What the I/O primitives of an interpreter may do. Every one of them checks
+// its permission first and raises a *PermissionError if it's not given.
+type Permissions struct {
+    Read    []string    // Directories under which files can be read
+    Write   []string    // Directories under which files can be written
+    Run     []string    // Programs that can be run; "*" for any
+    Env     []string    // Environment variables that can be read; "*" for any
+    Clock   bool        // Whether the time can be read
+    Stdio   bool        // Whether the interpreter's Stdin and Stdout can be used
+}
+
+// Permissions for everything, like a script run from the command line
+func AllowAll() Permissions {
+    return Permissions{
+        Read: []string{"/"}, Write: []string{"/"}, Run: []string{"*"}, Env: []string{"*"},
+        Clock: true, Stdio: true,
+    }
+}
+
+// No I/O at all; programs can only compute values
+func NoIO() Permissions {
+    return Permissions{}
+}
+
+type PermissionError struct {
+    Op      string      // Like "read" or "run"
+    What    string
+}
+
+func (e * PermissionError) Error() string {
+    if e.What == "" {
+        return fmt.Sprintf("Permission denied: %s", e.Op)
+    }
+    return fmt.Sprintf("Permission denied: %s %s", e.Op, e.What)
+}
+
+// The absolute path with symlinks resolved, as far as it exists
+func real_path(path string) string {
+    abs, err := filepath.Abs(path)
+    if err != nil {
+        return filepath.Clean(path)
+    }
+    if real, err := filepath.EvalSymlinks(abs); err == nil {
+        return real
+    }
+    // The file may not exist yet; resolve its directory
+    if dir, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+        return filepath.Join(dir, filepath.Base(abs))
+    }
+    return abs
+}
+
+// Whether path is under one of roots
+func under(path string, roots []string) bool {
+    path = real_path(path)
+    for _, root := range roots {
+        rel, err := filepath.Rel(real_path(root), path)
+        if err == nil && rel != ".." && ! strings.HasPrefix(rel, ".." + string(filepath.Separator)) {
+            return true
+        }
+    }
+    return false
+}
+
+func allowed(name string, list []string) bool {
+    for _, item := range list {
+        if item == "*" || item == name {
+            return true
+        }
+    }
+    return false
+}
+
+// Whether prog is in list, by name or by the file it runs
+func runnable(prog string, list []string) bool {
+    if allowed(prog, list) {
+        return true
+    }
+    path, err := exec.LookPath(prog)
+    if err != nil {
+        return false
+    }
+    for _, item := range list {
+        if other, err := exec.LookPath(item); err == nil && real_path(other) == real_path(path) {
+            return true
+        }
+    }
+    return false
+}
+
+func (it * Interpreter) check_read(path string) {
+    if ! under(path, it.Perms.Read) {
+        panic(&PermissionError{"read", path})
+    }
+}
+
+func (it * Interpreter) check_write(path string) {
+    if ! under(path, it.Perms.Write) {
+        panic(&PermissionError{"write", path})
+    }
+}
+
+func (it * Interpreter) check_stdio() {
+    if ! it.Perms.Stdio {
+        panic(&PermissionError{"stdio", ""})
+    }
+}
+
+func (it * Interpreter) check_clock() {
+    if ! it.Perms.Clock {
+        panic(&PermissionError{"clock", ""})
+    }
+}
+
+func str_arg(name string, args []*Object, i int) string {
+    if args[i].typ != OBJECT_STR {
+        panic(fmt.Sprintf("Argument %d of %s must be a string!", i + 1, name))
+    }
+    return args[i].val.(string)
+}
+
+// The built-ins for files, programs, the environment and the clock
+func io_builtins(it *Interpreter) map[string]*Object {
+    return map[string]*Object {
+        "read-file": NewPrim(func (args []*Object) *Object {
+            if len(args) != 1 {
+                panic("read-file needs a path!")
+            }
+            path := str_arg("read-file", args, 0)
+            it.check_read(path)
+            data, err := ioutil.ReadFile(path)
+            if err != nil {
+                raise(err)
+            }
+            it.check_items(len(data))
+            return NewStr(string(data))
+        }),
+        "write-file": NewPrim(func (args []*Object) *Object {
+            if len(args) != 2 {
+                panic("write-file needs a path and a string!")
+            }
+            path, data := str_arg("write-file", args, 0), str_arg("write-file", args, 1)
+            it.check_write(path)
+            if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+                raise(err)
+            }
+            return GYSP_NIL
+        }),
+        "getenv": NewPrim(func (args []*Object) *Object {
+            if len(args) != 1 {
+                panic("getenv needs a name!")
+            }
+            name := str_arg("getenv", args, 0)
+            if ! allowed(name, it.Perms.Env) {
+                panic(&PermissionError{"getenv", name})
+            }
+            if val, ok := os.LookupEnv(name); ok {
+                return NewStr(val)
+            }
+            return GYSP_NIL
+        }),
+        "run": NewPrim(func (args []*Object) *Object {     // (run prog args...); returns the output
+            if len(args) < 1 {
+                panic("run needs a program!")
+            }
+            argv := make([]string, len(args))
+            for i := range args {
+                argv[i] = str_arg("run", args, i)
+            }
+            if ! runnable(argv[0], it.Perms.Run) {
+                panic(&PermissionError{"run", argv[0]})
+            }
+            var out bytes.Buffer
+            cmd := exec.CommandContext(it.context(), argv[0], argv[1:]...)
+            cmd.Stdout = &out
+            cmd.Stderr = it.Stderr
+            if err := cmd.Run(); err != nil {
+                raise(err)
+            }
+            it.check_items(out.Len())
+            return NewStr(out.String())
+        }),
+        "now": NewPrim(func (args []*Object) *Object {     // Seconds since the epoch
+            if len(args) != 0 {
+                panic("now takes no arguments!")
+            }
+            it.check_clock()
+            return NewObject(OBJECT_FLOAT, float64(time.Now().UnixNano()) / 1e9)
+        }),
+    }
+}
@@ -0,0 +1,119 @@
+package eval
+
+import (
+    "bytes"
+    "errors"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// Checks that code raises a *PermissionError for op
+func check_denied(t *testing.T, it *Interpreter, code, op string) {
+    t.Helper()
+    _, err := it.Eval(code)
+    var perm *PermissionError
+    if ! errors.As(err, &perm) {
+        t.Errorf("%s: got %v, want a *PermissionError", code, err)
+    } else if perm.Op != op {
+        t.Errorf("%s: denied %s, want %s", code, perm.Op, op)
+    }
+}
+
+func TestPermissions(t *testing.T) {
+    dir, other := t.TempDir(), t.TempDir()
+    in, out := filepath.Join(dir, "in.txt"), filepath.Join(other, "out.txt")
+    if err := ioutil.WriteFile(in, []byte("data"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if err := ioutil.WriteFile(out, []byte("secret"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    os.Setenv("GYSP_TEST_VAR", "value")
+    defer os.Unsetenv("GYSP_TEST_VAR")
+
+    var stdout bytes.Buffer
+    it := NewInterpreter()
+    it.Stdin, it.Stdout = strings.NewReader("line\n"), &stdout
+    it.Define("in", in)
+    it.Define("out", out)
+    it.Define("new-in", filepath.Join(dir, "new.txt"))
+
+    // Nothing is allowed
+    it.Perms = NoIO()
+    check_denied(t, it, `(read-file in)`, "read")
+    check_denied(t, it, `(write-file new-in "x")`, "write")
+    check_denied(t, it, `(run "echo" "hi")`, "run")
+    check_denied(t, it, `(getenv "GYSP_TEST_VAR")`, "getenv")
+    check_denied(t, it, `(now)`, "clock")
+    check_denied(t, it, `(print 1)`, "stdio")
+    check_denied(t, it, `(println 1)`, "stdio")
+    check_denied(t, it, `(read-line)`, "stdio")
+
+    // Only what is given
+    it.Perms = Permissions{Read: []string{dir}, Write: []string{dir}, Run: []string{"echo"},
+        Env: []string{"GYSP_TEST_VAR"}, Clock: true, Stdio: true}
+    check_evals(t, it, []eval_test{
+        {`(read-file in)`, `"data"`},
+        {`(write-file new-in "x")`, "nil"},
+        {`(read-file new-in)`, `"x"`},
+        {`(run "echo" "hi")`, `"hi\n"`},
+        {`(getenv "GYSP_TEST_VAR")`, `"value"`},
+        {`(println "out")`, "nil"},
+        {`(read-line)`, `"line"`},
+    })
+    if got := eval_string(it, `(now)`); strings.HasPrefix(got, "error") {
+        t.Errorf("(now) = %s", got)
+    }
+    if stdout.String() != "out\n" {
+        t.Errorf("Printed %q", stdout.String())
+    }
+    check_denied(t, it, `(read-file out)`, "read")
+    check_denied(t, it, `(write-file out "x")`, "write")
+    check_denied(t, it, `(run "ls")`, "run")
+    check_denied(t, it, `(getenv "HOME")`, "getenv")
+}
+
+func TestPermissionErrorCaught(t *testing.T) {
+    it := NewInterpreter()
+    it.Perms = Permissions{Stdio: true}
+    it.Define("path", "/etc/passwd")
+    check_evals(t, it, []eval_test{
+        {`(try (read-file path) (catch e [(.op e) (.what e)]))`, "[read /etc/passwd]"},
+        {`(try (now) (catch e [e]))`, "[Permission denied: clock]"},
+    })
+    check_denied(t, it, `(try (now) (catch e (throw e)))`, "clock")     // Thrown again
+}
+
+func TestUnder(t *testing.T) {
+    root, outside := t.TempDir(), t.TempDir()
+    os.Mkdir(filepath.Join(root, "sub"), 0755)
+    os.Mkdir(root + "2", 0755)
+    defer os.Remove(root + "2")
+    os.Symlink(outside, filepath.Join(root, "out-link"))
+    os.Symlink(filepath.Join(root, "sub"), filepath.Join(root, "in-link"))
+    for _, test := range []struct {
+        path    string
+        ok      bool
+    }{
+        {root, true},
+        {filepath.Join(root, "a.txt"), true},
+        {filepath.Join(root, "sub", "..", "a.txt"), true},
+        {filepath.Join(root, "..", "a.txt"), false},
+        {filepath.Join(root, "sub", "..", "..", "a.txt"), false},
+        {root + "2", false},                                        // Only a common prefix
+        {filepath.Join(root, "out-link", "a.txt"), false},          // Symlinks are followed
+        {filepath.Join(root, "out-link"), false},
+        {filepath.Join(root, "in-link", "a.txt"), true},
+        {outside, false},
+    } {
+        if got := under(test.path, []string{root}); got != test.ok {
+            t.Errorf("under(%s) = %v, want %v", test.path, got, test.ok)
+        }
+    }
+    if ! under(filepath.Join(root, "a.txt"), []string{outside, root}) {
+        t.Errorf("Only the first root is checked")
+    }
+}
@@ -0,0 +1,122 @@
+package eval
+
+import (
+    "context"
+    "fmt"
+    "sync/atomic"
+)
+
+// Limits for running untrusted code. A zero field means no limit, except
+// for Depth, which is DEFAULT_DEPTH if it's zero and has no limit if it's
+// negative.
+type Limits struct {
+    Steps   int     // Evaluation steps (nodes evaluated, or calls and loop iterations on the VM) per evaluation
+    Depth   int     // Nesting of function calls in a call chain
+    Items   int     // Length of lists, dicts and strings made by range, + and Go functions
+}
+
+// Calls can nest this deep by default, so that runaway recursion is an error
+// instead of overflowing the Go stack. Depth is counted along each call chain:
+// functions called by primitives like swap! and pmap, and by goroutines, go
+// on from the depth of the call that started them, so recursing through
+// them is bounded too, but goroutines running at once don't add up. Calls
+// from Go, like Call(), start a new chain.
+const DEFAULT_DEPTH = 10000
+
+// Raised when an evaluation goes over a limit or its context is done. Unlike
+// other errors it can't be caught by try, so that sandboxed code can't keep
+// running past its limits.
+type LimitError struct {
+    Msg     string
+    Err     error       // The error of the context, if it's done
+}
+
+func (e * LimitError) Error() string {
+    return e.Msg
+}
+
+func (e * LimitError) Unwrap() error {
+    return e.Err
+}
+
+// Starts an evaluation (unless one is already running, like when a Go
+// function calls back into Gysp) with ctx; returns the function to end it.
+// The steps of the goroutines an evaluation starts count towards its limits. Ending it cancels its context without waiting for them, so
+// that they stop at their next wait instead of holding up later evaluations.
+func (it * Interpreter) begin(ctx context.Context) func() {
+    it.lock.Lock()
+    if it.running == 0 {
+        it.ctx, it.cancel = context.WithCancelCause(ctx)
+        atomic.StoreInt64(&it.steps, 0)
+    }
+    it.running ++
+    it.lock.Unlock()
+    return func() {
+        it.lock.Lock()
+        if it.running --; it.running == 0 {
+            it.cancel(nil)
+            it.ctx, it.cancel = context.Background(), nil
+        }
+        it.lock.Unlock()
+    }
+}
+
+// The context of the running evaluation
+func (it * Interpreter) context() context.Context {
+    it.lock.Lock()
+    defer it.lock.Unlock()
+    return it.ctx
+}
+
+// The context of the running evaluation and the function to stop it, like
+// when one of its goroutines goes over a limit
+func (it * Interpreter) evaluation() (context.Context, context.CancelCauseFunc) {
+    it.lock.Lock()
+    defer it.lock.Unlock()
+    if it.cancel == nil {       // Called from Go outside of an evaluation
+        return it.ctx, func(error) {}
+    }
+    return it.ctx, it.cancel
+}
+
+// The error for when the context of the evaluation is done
+func stopped(ctx context.Context) *LimitError {
+    if err, ok := context.Cause(ctx).(*LimitError); ok {
+        return err
+    }
+    return &LimitError{"Evaluation stopped: " + ctx.Err().Error(), ctx.Err()}
+}
+
+// Counts an evaluation step; the context is checked every 1024 steps
+func (it * Interpreter) step() {
+    steps := atomic.AddInt64(&it.steps, 1)
+    if it.Limits.Steps > 0 && steps > int64(it.Limits.Steps) {
+        panic(&LimitError{fmt.Sprintf("Step limit of %d exceeded!", it.Limits.Steps), nil})
+    }
+    if steps & 1023 == 0 {
+        ctx := it.context()
+        select {
+        case <-ctx.Done():
+            panic(stopped(ctx))
+        default:
+        }
+    }
+}
+
+// Checks the depth of a function call about to be made
+func (it * Interpreter) enter(calls int) {
+    max := it.Limits.Depth
+    if max == 0 {
+        max = DEFAULT_DEPTH
+    }
+    if max > 0 && calls > max {
+        panic(&LimitError{fmt.Sprintf("Maximum call depth of %d exceeded!", max), nil})
+    }
+}
+
+// Checks the length of a collection about to be made
+func (it * Interpreter) check_items(n int) {
+    if it != nil && it.Limits.Items > 0 && n > it.Limits.Items {
+        panic(&LimitError{fmt.Sprintf("Collection of %d items exceeds the limit of %d!", n, it.Limits.Items), nil})
+    }
+}
@@ -0,0 +1,166 @@
+package eval
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+)
+
+// An interpreter with (pos? n), which is nil unless n > 0, and (down n),
+// which recurses n calls deep
+func limited(limits Limits) *Interpreter {
+    it := NewInterpreter()
+    it.Limits = limits
+    it.Define("pos?", func (n int) *Object {
+        if n > 0 {
+            return GYSP_TRUE
+        }
+        return GYSP_NIL
+    })
+    it.Eval(`(defn down [n] (if (pos? n) (+ 1 (down (- n 1))) 0))`)
+    return it
+}
+
+// Checks that err is a *LimitError with the message msg
+func check_limit(t *testing.T, what string, err error, msg string) {
+    t.Helper()
+    var limit *LimitError
+    if ! errors.As(err, &limit) {
+        t.Errorf("%s: got %v, want a *LimitError", what, err)
+    } else if limit.Msg != msg {
+        t.Errorf("%s: got %q, want %q", what, limit.Msg, msg)
+    }
+}
+
+func TestStepLimit(t *testing.T) {
+    it := limited(Limits{Steps: 100})
+    _, err := it.Eval(`(for [i (range 1000)] i)`)
+    check_limit(t, "loop", err, "Step limit of 100 exceeded!")
+    _, err = it.Eval(`(try (for [i (range 1000)] i) (catch e 1))`)
+    check_limit(t, "caught", err, "Step limit of 100 exceeded!")
+    if got := eval_string(it, `(+ 1 2)`); got != "3" {     // Each evaluation has its own steps
+        t.Errorf("After the limit, (+ 1 2) = %s", got)
+    }
+}
+
+func TestDepthLimit(t *testing.T) {
+    it := limited(Limits{Steps: 10000000})      // The default depth
+    if got := eval_string(it, `(down 1000)`); got != "1000" {
+        t.Errorf("(down 1000) = %s", got)
+    }
+    _, err := it.Eval(`(down 20000)`)
+    check_limit(t, "default", err, "Maximum call depth of 10000 exceeded!")
+
+    it.Limits.Depth = 50
+    if got := eval_string(it, `(down 49)`); got != "49" {
+        t.Errorf("(down 49) = %s", got)
+    }
+    _, err = it.Eval(`(down 50)`)
+    check_limit(t, "50", err, "Maximum call depth of 50 exceeded!")
+
+    it.Limits.Depth = -1
+    if got := eval_string(it, `(down 20000)`); got != "20000" {
+        t.Errorf("(down 20000) without a limit = %s", got)
+    }
+}
+
+func TestItemLimit(t *testing.T) {
+    it := limited(Limits{Items: 10})
+    check_evals(t, it, []eval_test{
+        {`(range 10)`, "[0 1 2 3 4 5 6 7 8 9]"},
+        {`(+ "aaaaa" "bbbbb")`, `"aaaaabbbbb"`},
+    })
+    _, err := it.Eval(`(range 11)`)
+    check_limit(t, "range", err, "Collection of 11 items exceeds the limit of 10!")
+    _, err = it.Eval(`(+ "aaaaa" "bbbbb" "c")`)
+    check_limit(t, "string", err, "Collection of 11 items exceeds the limit of 10!")
+}
+
+func TestContextLimit(t *testing.T) {
+    it := limited(Limits{})
+    ctx, cancel := context.WithTimeout(context.Background(), 20 * time.Millisecond)
+    defer cancel()
+    start := time.Now()
+    _, err := it.EvalContext(ctx, `(for [i (range 1000) j (range 1000) k (range 1000)] i)`)
+    check_limit(t, "timeout", err, "Evaluation stopped: context deadline exceeded")
+    if ! errors.Is(err, context.DeadlineExceeded) {
+        t.Errorf("%v doesn't wrap the error of the context", err)
+    }
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Errorf("Stopped after %v", elapsed)
+    }
+}
+
+// Evaluations don't wait for the goroutines and futures they start, so one
+// left waiting doesn't keep the next ones from having their own context and
+// steps
+func TestBlockedGoroutine(t *testing.T) {
+    it := limited(Limits{Steps: 50})
+    if got := eval_string(it, `(go recv (chan))`); got != "nil" {
+        t.Fatalf("(go recv (chan)) = %s", got)
+    }
+    if got := eval_string(it, `(future recv (chan))`); got != "<future>" {
+        t.Fatalf("(future recv (chan)) = %s", got)
+    }
+    for i := 0; i < 10; i ++ {
+        if got := eval_string(it, `(do (for [i (range 10)] i) 1)`); got != "1" {
+            t.Fatalf("Evaluation %d gave %s", i, got)
+        }
+    }
+
+    it.Limits = Limits{}
+    ctx, cancel := context.WithTimeout(context.Background(), 20 * time.Millisecond)
+    defer cancel()
+    _, err := it.EvalContext(ctx, `(for [i (range 1000) j (range 1000) k (range 1000)] i)`)
+    check_limit(t, "timeout", err, "Evaluation stopped: context deadline exceeded")
+}
+
+// On the VM, steps are counted by calls and loop iterations
+func TestVMLimits(t *testing.T) {
+    it := limited(Limits{Steps: 100})
+    it.VM = true
+    it.Eval(`(defn down [n] (if (pos? n) (+ 1 (down (- n 1))) 0))`)
+    _, err := it.Eval(`(for [i (range 1000)] i)`)
+    check_limit(t, "loop", err, "Step limit of 100 exceeded!")
+    _, err = it.Eval(`(down 100)`)
+    check_limit(t, "calls", err, "Step limit of 100 exceeded!")
+    if got := eval_string(it, `(down 10)`); got != "10" {
+        t.Errorf("(down 10) = %s", got)
+    }
+
+    it.Limits = Limits{Depth: 50}
+    _, err = it.Eval(`(down 50)`)
+    check_limit(t, "depth", err, "Maximum call depth of 50 exceeded!")
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20 * time.Millisecond)
+    defer cancel()
+    _, err = it.EvalContext(ctx, `(for [i (range 1000) j (range 1000) k (range 1000)] i)`)
+    check_limit(t, "timeout", err, "Evaluation stopped: context deadline exceeded")
+}
+
+// Each call chain has its own depth, even when goroutines run at once
+func TestDepthPerChain(t *testing.T) {
+    it := limited(Limits{Depth: 1000})
+    it.Parallelism = 4
+    it.Eval(`(set ready (chan 2) go-on (chan 2) done (chan 2))
+        (defn hold [n] (if (pos? n) (+ 1 (hold (- n 1))) (do (send ready 1) (recv go-on) 0)))`)
+    check_evals(t, it, []eval_test{
+        {`(down 600)`, "600"},
+        {`(pmap down [600 600 600 600])`, "[600 600 600 600]"},
+        // Both are 600 calls deep before either returns
+        {`(do (set a (future hold 600) b (future hold 600))
+            (recv ready) (recv ready) (send go-on 1) (send go-on 1)
+            [(deref a) (deref b)])`, "[600 600]"},
+        {`(do (go (fn [] (send done (hold 600)))) (go (fn [] (send done (hold 600))))
+            (recv ready) (recv ready) (send go-on 1) (send go-on 1)
+            [(recv done) (recv done)])`, "[600 600]"},
+    })
+
+    // Calls made by primitives continue the chain of their caller
+    _, err := it.Eval(`(do (set a (atom 0)) (defn again [x] (swap! a again)) (again 0))`)
+    check_limit(t, "swap!", err, "Maximum call depth of 1000 exceeded!")
+    _, err = it.Eval(`(defn deeper [n] (if (pos? n) (+ 1 (deeper (- n 1))) (pmap down [600])))
+        (deeper 600)`)
+    check_limit(t, "pmap", err, "Maximum call depth of 1000 exceeded!")
+}
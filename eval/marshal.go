@@ -0,0 +1,75 @@
+package eval
+
+import (
+    "fmt"
+    "reflect"
+    "strings"
+    "unicode"
+)
+
+// Go values <-- ToGo / Unmarshal --- Objects --- FromGo / Marshal --> ...
+//
+// Struct fields map to dict keys named by their `gysp:"name"` tags, or else
+// by their Go names in kebab-case, like `MaxRetries' to "max-retries". A tag
+// of "-" hides a field, and ",omitempty" leaves out zero values when
+// marshalling.
+
+// The Go value of o in its natural type (see natural())
+func ToGo(o *Object) interface{} {
+    return natural(o)
+}
+
+// Converts a Go value to an object; functions become primitives, and
+// pointers to structs and values like channels become Go objects
+func FromGo(v interface{}) *Object {
+    return from_go(reflect.ValueOf(v), nil, false)
+}
+
+// Stores o in the value v points to, converting it to v's type
+func Unmarshal(o *Object, v interface{}) (err error) {
+    ptr := reflect.ValueOf(v)
+    if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+        return fmt.Errorf("Unmarshal needs a non-nil pointer, not %T!", v)
+    }
+    defer catch(&err)
+    ptr.Elem().Set(to_go(o, ptr.Elem().Type()))
+    return nil
+}
+
+// Converts a Go value (like a struct) to an object, following pointers
+func Marshal(v interface{}) (o *Object, err error) {
+    defer catch(&err)
+    return from_go(reflect.ValueOf(v), nil, true), nil
+}
+
+// Converts a CamelCase Go name to kebab-case; runs of capitals are kept
+// together, so `HTTPServer' becomes "http-server"
+func KebabCase(name string) string {
+    runes := []rune(name)
+    var b strings.Builder
+    for i, r := range runes {
+        if unicode.IsUpper(r) && i > 0 {
+            prev := runes[i - 1]
+            next_lower := i + 1 < len(runes) && unicode.IsLower(runes[i + 1])
+            if unicode.IsLower(prev) || unicode.IsDigit(prev) || unicode.IsUpper(prev) && next_lower {
+                b.WriteByte('-')
+            }
+        }
+        b.WriteRune(unicode.ToLower(r))
+    }
+    return b.String()
+}
+
+// Converts a kebab-case name to CamelCase, like "max-retries" to `MaxRetries'
+func CamelCase(name string) string {
+    var b strings.Builder
+    for _, part := range strings.Split(name, "-") {
+        runes := []rune(part)
+        if len(runes) == 0 {
+            continue
+        }
+        b.WriteRune(unicode.ToUpper(runes[0]))
+        b.WriteString(string(runes[1:]))
+    }
+    return b.String()
+}
@@ -0,0 +1,152 @@
+package eval
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+)
+
+type test_retry struct {
+    Max         int
+    Backoff     float64
+}
+
+type test_server struct {
+    Name        string              `gysp:"host-name"`
+    Port        int
+    Tags        []string
+    Limits      map[string]int
+    Retry       test_retry
+    Fallback    *test_retry
+    Secret      string              `gysp:"-"`
+    Note        string              `gysp:",omitempty"`
+    TLSConfig   string
+    hidden      int
+}
+
+var TEST_SERVER = test_server{
+    Name: "db", Port: 5432, Tags: []string{"a", "b"}, Limits: map[string]int{"conns": 10},
+    Retry: test_retry{3, 0.5}, Fallback: &test_retry{1, 2}, Secret: "hunter2", TLSConfig: "tls",
+}
+
+// TEST_SERVER marshalled, as given back by ToGo()
+var TEST_SERVER_GO = map[string]interface{}{
+    "host-name": "db", "port": 5432, "tags": []interface{}{"a", "b"},
+    "limits": map[string]interface{}{"conns": 10},
+    "retry": map[string]interface{}{"max": 3, "backoff": 0.5},
+    "fallback": map[string]interface{}{"max": 1, "backoff": 2.0},
+    "tls-config": "tls",
+}
+
+func TestMarshal(t *testing.T) {
+    o, err := Marshal(TEST_SERVER)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if ! reflect.DeepEqual(ToGo(o), TEST_SERVER_GO) {
+        t.Errorf("Marshalled to %s", o.GoString())
+    }
+    with_note := TEST_SERVER
+    with_note.Note = "hi"
+    if o, _ := Marshal(&with_note); ! strings.Contains(o.GoString(), "note: hi") {
+        t.Errorf("Note left out of %s", o.GoString())
+    }
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+    o, err := Marshal(TEST_SERVER)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var back test_server
+    if err := Unmarshal(o, &back); err != nil {
+        t.Fatal(err)
+    }
+    want := TEST_SERVER
+    want.Secret = ""        // Hidden from Gysp
+    if ! reflect.DeepEqual(back, want) {
+        t.Errorf("Got back %+v, want %+v", back, want)
+    }
+
+    for _, v := range []interface{}{
+        map[int]string{1: "a", 2: "b"},
+        []map[string][]int{{"x": {1, 2}}, {"y": nil}},
+        [2]bool{true, false},
+        map[string]*test_retry{"r": {4, 1.5}},
+    } {
+        o, err := Marshal(v)
+        if err != nil {
+            t.Fatal(err)
+        }
+        back := reflect.New(reflect.TypeOf(v))
+        if err := Unmarshal(o, back.Interface()); err != nil {
+            t.Fatalf("%v: %v", o, err)
+        }
+        if ! reflect.DeepEqual(back.Elem().Interface(), v) {
+            t.Errorf("%#v came back as %#v", v, back.Elem().Interface())
+        }
+    }
+}
+
+func TestUnmarshalFromGysp(t *testing.T) {
+    it := NewInterpreter()
+    o, err := it.Eval(`{"host-name" "web" "port" 80 "tags" ["x"] "retry" {"max" 2} "tls-config" "on"}`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var s test_server
+    if err := Unmarshal(o, &s); err != nil {
+        t.Fatal(err)
+    }
+    want := test_server{Name: "web", Port: 80, Tags: []string{"x"}, Retry: test_retry{Max: 2}, TLSConfig: "on"}
+    if ! reflect.DeepEqual(s, want) {
+        t.Errorf("Got %+v, want %+v", s, want)
+    }
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+    it := NewInterpreter()
+    var s test_server
+    for _, test := range []struct {
+        code    string
+        into    interface{}
+        err     string
+    }{
+        {`{"port" "eighty"}`, &s, "Cannot convert string to Go int!"},
+        {`{"secret" "x"}`, &s, "Go eval.test_server has no field 'secret'!"},
+        {`{"hidden" 1}`, &s, "Go eval.test_server has no field 'hidden'!"},
+        {`{1 2}`, &s, "Field names of Go eval.test_server must be strings!"},
+        {`[1 2]`, &s, "Cannot convert list to Go eval.test_server!"},
+        {`{"tags" [1]}`, &s, "Cannot convert int to Go string!"},
+        {`{"retry" {"max" 1.5}}`, &s, "Cannot convert float to Go int!"},
+        {`[1 2 3]`, new([2]int), "Cannot convert list to Go [2]int!"},
+        {`1`, s, "Unmarshal needs a non-nil pointer, not eval.test_server!"},
+    } {
+        o, err := it.Eval(test.code)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := Unmarshal(o, test.into); err == nil || err.Error() != test.err {
+            t.Errorf("Unmarshal(%s) gave %v, want %s", test.code, err, test.err)
+        }
+    }
+}
+
+func TestToGoFromGo(t *testing.T) {
+    for _, v := range []interface{}{
+        nil, true, 1, 2.5, 1 + 2i, "s",
+        []interface{}{1, "a", []interface{}{nil}},
+        map[string]interface{}{"a": 1, "b": []interface{}{"c"}},
+        map[interface{}]interface{}{1: "one", "two": 2},
+    } {
+        if back := ToGo(FromGo(v)); ! reflect.DeepEqual(back, v) {
+            t.Errorf("%#v came back as %#v", v, back)
+        }
+    }
+    if o := FromGo(&TEST_SERVER); o.Typ() != OBJECT_GO || ToGo(o) != &TEST_SERVER {
+        t.Errorf("A pointer to a struct became %s", o.GoString())
+    }
+    if o := FromGo(TEST_SERVER.Retry); ! reflect.DeepEqual(ToGo(o), TEST_SERVER_GO["retry"]) {
+        t.Errorf("A struct became %s", o.GoString())
+    }
+}
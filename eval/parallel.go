@@ -0,0 +1,178 @@
+package eval
+
+import (
+    "fmt"
+    "runtime"
+    "sync"
+    "sync/atomic"
+)
+
+// Parallel operations on lists, run by a pool of at most Parallelism
+// goroutines, and futures. Like goroutines, they count towards the limits of
+// the evaluation, and a future still being computed when it ends is stopped
+// at its next wait. If a call fails, no more items are started, and the first
+// error is raised again in the caller once the running calls are done.
+
+// Number of goroutines for parallel operations
+func (it * Interpreter) workers() int {
+    if it.Parallelism > 0 {
+        return it.Parallelism
+    }
+    return runtime.GOMAXPROCS(0)
+}
+
+// Calls fn(i) for i from 0 to n - 1 in parallel
+func (it * Interpreter) parallel(n int, fn func(i int)) {
+    workers := it.workers()
+    if workers > n {
+        workers = n
+    }
+    var (
+        next    int64 = -1
+        stop    int32
+        once    sync.Once
+        first   interface{}     // The first panic
+        wg      sync.WaitGroup
+    )
+    wg.Add(workers)
+    for w := 0; w < workers; w ++ {
+        go func() {
+            defer wg.Done()
+            defer func() {
+                if e := recover(); e != nil {
+                    once.Do(func() { first = e })
+                    atomic.StoreInt32(&stop, 1)
+                }
+            }()
+            for atomic.LoadInt32(&stop) == 0 {
+                i := int(atomic.AddInt64(&next, 1))
+                if i >= n {
+                    return
+                }
+                fn(i)
+            }
+        }()
+    }
+    wg.Wait()
+    if first != nil {
+        panic(first)
+    }
+}
+
+func list_of(name string, o *Object) []*Object {
+    if o.typ != OBJECT_LIST {
+        panic(fmt.Sprintf("%s needs a list, not a %s!", name, o.typ.String()))
+    }
+    return o.val.([]*Object)
+}
+
+// A value being computed by a goroutine
+type Future struct {
+    done    chan struct{}   // Closed when it's computed
+    val     *Object
+    err     interface{}     // The panic that ended the goroutine, if any
+}
+
+// Waits for the value; an error computing it is raised again
+func (it * Interpreter) wait(f *Future) *Object {
+    ctx := it.context()
+    select {
+    case <-f.done:
+    case <-ctx.Done():
+        panic(stopped(ctx))
+    }
+    if f.err != nil {
+        panic(f.err)
+    }
+    return f.val
+}
+
+func parallel_builtins(it *Interpreter) map[string]*Object {
+    return map[string]*Object {
+        "pmap": new_caller(func (args []*Object, env *Env) *Object {   // (pmap f list)
+            if len(args) != 2 {
+                panic("pmap needs a function and a list!")
+            }
+            fn, items := args[0], list_of("pmap", args[1])
+            results := make([]*Object, len(items))
+            it.parallel(len(items), func(i int) {
+                results[i] = call(fn, []*Object{items[i]}, env)
+            })
+            return NewObject(OBJECT_LIST, results)
+        }),
+        "pfilter": new_caller(func (args []*Object, env *Env) *Object {    // (pfilter pred list)
+            if len(args) != 2 {
+                panic("pfilter needs a function and a list!")
+            }
+            pred, items := args[0], list_of("pfilter", args[1])
+            keep := make([]bool, len(items))
+            it.parallel(len(items), func(i int) {
+                keep[i] = call(pred, []*Object{items[i]}, env) != GYSP_NIL
+            })
+            results := make([]*Object, 0, len(items))
+            for i, item := range items {
+                if keep[i] {
+                    results = append(results, item)
+                }
+            }
+            return NewObject(OBJECT_LIST, results)
+        }),
+        // (preduce f init list) reduces parts of the list in parallel, each
+        // starting from init, and then reduces their results; so f must be
+        // associative, and init its identity, like + and 0
+        "preduce": new_caller(func (args []*Object, env *Env) *Object {
+            if len(args) != 3 {
+                panic("preduce needs a function, an initial value and a list!")
+            }
+            fn, init, items := args[0], args[1], list_of("preduce", args[2])
+            reduce := func(items []*Object) *Object {
+                acc := init
+                for _, item := range items {
+                    acc = call(fn, []*Object{acc, item}, env)
+                }
+                return acc
+            }
+            parts := it.workers()
+            if parts > len(items) {
+                parts = len(items)
+            }
+            results := make([]*Object, parts)
+            it.parallel(parts, func(i int) {
+                results[i] = reduce(items[i * len(items) / parts:(i + 1) * len(items) / parts])
+            })
+            return reduce(results)
+        }),
+        "future": new_caller(func (args []*Object, env *Env) *Object { // (future f args...); deref waits for the value
+            if len(args) < 1 {
+                panic("future needs a function!")
+            }
+            f := &Future{done: make(chan struct{})}
+            _, stop := it.evaluation()
+            fun, fun_args := args[0], append([]*Object(nil), args[1:]...)      // args may be a view of the VM stack
+            go func() {
+                defer close(f.done)
+                defer func() {
+                    if e := recover(); e != nil {
+                        f.err = e
+                        if err, ok := e.(*LimitError); ok {
+                            stop(err)
+                        }
+                    }
+                }()
+                f.val = call(fun, fun_args, env)
+            }()
+            return NewObject(OBJECT_FUTURE, f)
+        }),
+        "realized?": NewPrim(func (args []*Object) *Object {   // Whether a future is done
+            if len(args) != 1 || args[0].typ != OBJECT_FUTURE {
+                panic("realized? needs a future!")
+            }
+            select {
+            case <-args[0].val.(*Future).done:
+                return GYSP_TRUE
+            default:
+                return GYSP_NIL
+            }
+        }),
+    }
+}
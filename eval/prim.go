@@ -14,10 +14,47 @@ func NewPrim(f func([]*Object) *Object) *Object {
     return NewObject(OBJECT_PRIM, f)
 }
 
+// A primitive that calls functions, given the frame it's called from so that
+// their calls continue its call chain
+func new_caller(f func([]*Object, *Env) *Object) *Object {
+    return NewObject(OBJECT_PRIM, f)
+}
+
+// Small integers are made once and shared, like GYSP_NIL
+const (
+    SMALL_INT_MIN = -128
+    SMALL_INT_MAX = 1023
+)
+
+var small_ints [SMALL_INT_MAX - SMALL_INT_MIN + 1]*Object      // Shared by all interpreters; never changed
+
+func init() {
+    for i := range small_ints {
+        small_ints[i] = NewObject(OBJECT_INT, i + SMALL_INT_MIN)
+    }
+}
+
 func NewInt(i int) *Object {
+    if i >= SMALL_INT_MIN && i <= SMALL_INT_MAX {
+        return small_ints[i - SMALL_INT_MIN]
+    }
     return NewObject(OBJECT_INT, i)
 }
 
+func NewStr(s string) *Object {
+    if s == "" {
+        return GYSP_EMPTY_STR
+    }
+    return NewObject(OBJECT_STR, s)
+}
+
+func NewBool(b bool) *Object {
+    if b {
+        return GYSP_TRUE
+    }
+    return GYSP_FALSE
+}
+
 // Helper methods
 func convert_err(t1, t2 ObjectType) *Object {
     panic(fmt.Sprintf("Cannot convert %v to %v!", t1, t2))
@@ -64,13 +101,13 @@ func add(a, b *Object) *Object {
 
     switch typ {
     case OBJECT_INT:
-        return NewObject(OBJECT_INT, a.val.(int) + b.val.(int))
+        return NewInt(a.val.(int) + b.val.(int))
     case OBJECT_FLOAT:
         return NewObject(OBJECT_FLOAT, a.val.(float64) + b.val.(float64))
     case OBJECT_CMPLX:
         return NewObject(OBJECT_CMPLX, a.val.(complex128) + b.val.(complex128))
     case OBJECT_STR:
-        return NewObject(OBJECT_STR, a.val.(string) + b.val.(string))
+        return NewStr(a.val.(string) + b.val.(string))
     }
     return GYSP_NIL
 }
@@ -78,7 +115,7 @@ func add(a, b *Object) *Object {
 func negate(a *Object) *Object {
     switch a.typ {
     case OBJECT_INT:
-        return NewObject(OBJECT_INT, -a.val.(int))
+        return NewInt(-a.val.(int))
     case OBJECT_FLOAT:
         return NewObject(OBJECT_FLOAT, -a.val.(float64))
     case OBJECT_CMPLX:
@@ -97,7 +134,7 @@ func sub(a, b *Object) *Object {
 
     switch typ {
     case OBJECT_INT, OBJECT_FLOAT, OBJECT_CMPLX, OBJECT_STR:
-        return NewObject(OBJECT_INT, add(a, negate(b)))
+        return add(a, negate(b))
     }
     return GYSP_NIL
 }
@@ -110,7 +147,7 @@ func mul(a, b *Object) *Object {
 
     switch typ {
     case OBJECT_INT:
-        return NewObject(OBJECT_INT, a.val.(int) * b.val.(int))
+        return NewInt(a.val.(int) * b.val.(int))
     case OBJECT_FLOAT:
         return NewObject(OBJECT_FLOAT, a.val.(float64) * b.val.(float64))
     case OBJECT_CMPLX:
@@ -121,6 +158,14 @@ func mul(a, b *Object) *Object {
     return GYSP_NIL
 }
 
+// The first of the arguments of an arithmetic primitive
+func first_arg(name string, args []*Object) *Object {
+    if len(args) == 0 {
+        panic(fmt.Sprintf("%s needs at least one argument!", name))
+    }
+    return args[0]
+}
+
 func div(a, b *Object) *Object {
     typ := a.typ
     if typ != b.typ {
@@ -129,7 +174,10 @@ func div(a, b *Object) *Object {
 
     switch typ {
     case OBJECT_INT:
-        return NewObject(OBJECT_INT, a.val.(int) / b.val.(int))
+        if b.val.(int) == 0 {
+            panic("Division by zero!")
+        }
+        return NewInt(a.val.(int) / b.val.(int))
     case OBJECT_FLOAT:
         return NewObject(OBJECT_FLOAT, a.val.(float64) / b.val.(float64))
     case OBJECT_CMPLX:
@@ -148,7 +196,10 @@ func mod(a, b *Object) *Object {
 
     switch typ {
     case OBJECT_INT:
-        return NewObject(OBJECT_INT, a.val.(int) % b.val.(int))
+        if b.val.(int) == 0 {
+            panic("Modulus by zero!")
+        }
+        return NewInt(a.val.(int) % b.val.(int))
     case OBJECT_FLOAT:
         return NewObject(OBJECT_FLOAT, math.Mod(a.val.(float64), b.val.(float64)))
     case OBJECT_CMPLX:
@@ -167,7 +218,7 @@ func pow(a, b *Object) *Object {
 
     switch typ {
     case OBJECT_INT:
-        return NewObject(OBJECT_INT, a.val.(int) % b.val.(int))
+        return NewInt(a.val.(int) % b.val.(int))
     case OBJECT_FLOAT:
         return NewObject(OBJECT_FLOAT, math.Pow(a.val.(float64), b.val.(float64)))
     case OBJECT_CMPLX:
@@ -178,19 +229,73 @@ func pow(a, b *Object) *Object {
     return GYSP_NIL
 }
 
+// Raised (as a panic) by the exit primitive; whoever runs the program ends
+// the process with the code
+type Exit int
+
+func (e Exit) Error() string {
+    return fmt.Sprintf("exit %d", int(e))
+}
+
+// Number of items of a range
+func range_len(start, end, step int) int {
+    switch {
+    case step > 0 && end > start:
+        return (end - start + step - 1) / step
+    case step < 0 && end < start:
+        return (start - end - step - 1) / -step
+    case step == 0:
+        panic("Range step must not be 0!")
+    }
+    return 0
+}
+
 func Range(start, end, step int) *Object {
-    list := make([]*Object, (end - start) / step)
-    j := 0      // Just a counter
-    for i := start; i < end; i += step {
-        list[j] = NewInt(i)
-        j ++
+    list := make([]*Object, range_len(start, end, step))
+    for j := range list {
+        list[j] = NewInt(start + j * step)
     }
     return NewObject(OBJECT_LIST, list)
 }
 
+// Sets a reference (as left by Resolve()) to val. A variable that isn't
+// defined yet is created in the current scope.
+func assign(ref parse.Node, val *Object, env *Env) {
+    switch r := ref.(type) {
+    case *LocalNode:
+        env.SetLocal(r.Depth, r.Slot, val)
+    case *parse.SymNode:
+        env.it.check_frozen(r.Sym)
+        if scope, _ := env.find(r.Sym); scope != nil {
+            env.set(r.Sym, val)
+        } else {
+            env.bind(r.Sym, val)
+        }
+    default:
+        panic(fmt.Sprintf("Cannot set %s!", ref))
+    }
+}
+
+// Makes a function from the parameter list and body of a fn
+func new_func(name string, args []parse.Node, env *Env) *Object {
+    if len(args) < 2 {
+        panic("A function needs a parameter list and a body!")
+    }
+    vars := sym_list(args[0])
+    if vars == nil {
+        panic("Parameters must be a list of symbols!")
+    }
+    return NewObject(OBJECT_FUNC, &Func{name, vars, env, args[1:], nil})
+}
+
+// The global environment of a new interpreter writing to the standard output
 func StandardEnv() *Env {
-    return &Env{
-        map[string]*Object {
+    return NewInterpreter().Env
+}
+
+// The built-ins of it; I/O goes through its readers and writers
+func builtins(it *Interpreter) map[string]*Object {
+    return map[string]*Object {
         // Constants
         "nil": GYSP_NIL,
         "true": GYSP_TRUE,
@@ -198,28 +303,31 @@ func StandardEnv() *Env {
 
         // Primitives
         "+": NewPrim(func (args []*Object) *Object {
-            sum := args[0]
+            sum := first_arg("+", args)
             for i := 1; i < len(args); i ++ {
                 sum = add(sum, args[i])
+                if sum.typ == OBJECT_STR {
+                    it.check_items(len(sum.val.(string)))
+                }
             }
             return sum
         }),
         "-": NewPrim(func (args []*Object) *Object {
-            sum := args[0]
+            sum := first_arg("-", args)
             for i := 1; i < len(args); i ++ {
                 sum = sub(sum, args[i])
             }
             return sum
         }),
         "*": NewPrim(func (args []*Object) *Object {
-            product := args[0]
+            product := first_arg("*", args)
             for i := 1; i < len(args); i ++ {
                 product = mul(product, args[i])
             }
             return product
         }),
         "/": NewPrim(func (args []*Object) *Object {
-            product := args[0]
+            product := first_arg("/", args)
             for i := 1; i < len(args); i ++ {
                 product = div(product, args[i])
             }
@@ -233,117 +341,67 @@ func StandardEnv() *Env {
         }),
 
         "range": NewPrim(func (args []*Object) *Object {
+            for _, arg := range args {
+                if arg.typ != OBJECT_INT {
+                    panic("Arguments of range() must be ints!")
+                }
+            }
+            start, end, step := 0, 0, 1
             switch len(args) {
             case 1:     // Only stop
-                return Range(0, args[0].val.(int), 1)
+                end = args[0].val.(int)
             case 2:     // Start and stop
-                return Range(args[0].val.(int), args[1].val.(int), 1)
+                start, end = args[0].val.(int), args[1].val.(int)
             case 3:     // Start, stop and step
-                return Range(args[0].val.(int), args[1].val.(int), args[2].val.(int))
+                start, end, step = args[0].val.(int), args[1].val.(int), args[2].val.(int)
+            default:
+                panic("Invalid arguemnts for range()!")
             }
-            panic("Invalid arguemnts for range()!")
+            it.check_items(range_len(start, end, step))
+            return Range(start, end, step)
         }),
         "print": NewPrim(func (args []*Object) *Object {
-            converted := make([]interface{}, len(args))
-            for i := 0; i < len(args); i ++ {
-                converted[i] = args[i]
-            }
-            fmt.Print(converted...)
+            it.check_stdio()
+            it.print(fmt.Fprint, args)
             return GYSP_NIL
         }),
         "println": NewPrim(func (args []*Object) *Object {
-            converted := make([]interface{}, len(args))
-            for i := 0; i < len(args); i ++ {
-                converted[i] = args[i]
-            }
-            fmt.Println(converted...)
+            it.check_stdio()
+            it.print(fmt.Fprintln, args)
             return GYSP_NIL
         }),
-
-        "if": NewMacro(func (args []parse.Node, env *Env) parse.Node {
-            if eval(args[0], env) != GYSP_NIL {
-                return args[1]
-            }
-            if len(args) > 2 {
-                return args[2]
+        "read-line": NewPrim(func (args []*Object) *Object {
+            if len(args) != 0 {
+                panic("read-line takes no arguments!")
             }
-            return parse.NIL_NODE
-        }),
-
-        "for": NewMacro(func (args []parse.Node, env *Env) parse.Node {
-            _ranges, ok := args[0].(*parse.ListNode)
+            it.check_stdio()
+            line, ok := it.read_line()
             if ! ok {
-                panic("Expected list!")
-            }
-
-            ranges := _ranges.List
-            range_len := len(ranges)
-            if range_len % 2 != 0 {
-                panic("Range list must have a even number of items!")
-            }
-            vars, lists := make([]string, range_len / 2), make([]*Object, range_len / 2)
-            for i := 0; i < range_len / 2; i ++ {
-                vars[i] = ranges[2 * i].(*parse.SymNode).Name
-                lists[i] = eval(ranges[2 * i + 1], env)
-                if lists[i].typ != OBJECT_LIST {
-                    panic("Range source must be a list!")
-                }
+                return GYSP_NIL
             }
-
-            inner_env := NewEnv(env)
-            gen := NewGenerator()
-            for _, list := range lists {
-                gen.AddSource(list.val.([]*Object))
-            }
-            gen.Generate(func(as []*Object) *Object {
-                // Set loop variables
-                for i := 0; i < len(vars); i ++ {
-                    inner_env.SetVarX(vars[i], as[i])
-                }
-                // Run body
-                for i := 1; i < len(args) - 1; i ++ {
-                    eval(args[i], inner_env)
-                }
-                return eval(args[len(args) - 1], inner_env)     // TODO print results on repl
-            })
-            return parse.NIL_NODE
+            return NewStr(line)
         }),
-
-        "let": NewMacro(func (args []parse.Node, env *Env) parse.Node {
-            _bindings, ok := args[0].(*parse.ListNode)
-            if ! ok {
-                panic("Expected list!")
+        "throw": NewPrim(func (args []*Object) *Object {
+            if len(args) != 1 {
+                panic("throw needs a value!")
             }
-
-            bindings := _bindings.List
-            bind_len := len(bindings)
-            if bind_len % 2 != 0 {
-                panic("Binding list must have a even number of items!")
+            exc := &Exception{args[0], nil}
+            if err, ok := args[0].val.(error); ok && args[0].typ == OBJECT_GO {  // Thrown again
+                exc.Err = err
             }
-
-            // Set bindings
-            inner_env := NewEnv(env)
-            for i := 0; i < bind_len / 2; i ++ {
-                inner_env.SetVarX(
-                    bindings[2 * i].(*parse.SymNode).Name,
-                    eval(bindings[2 * i + 1], env))
-            }
-
-            // Run body
-            for i := 1; i < len(args) - 1; i ++ {       // Only the calls before the last
-                eval(args[i], inner_env)
-            }
-            return WrapObject(eval(args[len(args) - 1], inner_env))
+            panic(exc)
         }),
-
-        "do": NewMacro(func (args []parse.Node, env *Env) parse.Node {
-            // Create inner environment
-            inner_env := NewEnv(env)
-            // Run body
-            for i := 0; i < len(args) - 1; i ++ {
-                eval(args[i], inner_env)
+        "exit": NewPrim(func (args []*Object) *Object {
+            switch len(args) {
+            case 0:
+                panic(Exit(0))
+            case 1:
+                if args[0].typ != OBJECT_INT {
+                    panic("Exit code must be an int!")
+                }
+                panic(Exit(args[0].val.(int)))
             }
-            return WrapObject(eval(args[len(args) - 1], inner_env))
+            panic("Invalid arguments for exit()!")
         }),
-    }, nil}
+    }
 }
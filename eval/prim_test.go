@@ -0,0 +1,37 @@
+package eval
+
+import (
+    "testing"
+)
+
+// Tight numeric loops, to keep an eye on allocations
+var NUMERIC_PROGS = []struct {
+    name    string
+    code    string
+}{
+    {"small-int", `(for [i (range 1000)] (+ (* i 2) 1 (- i 10) (% i 7)))`},
+    {"big-int", `(for [i (range 1000)] (+ (* i 4096) 100000))`},
+    {"float", `(for [i (range 1000)] (+ (* 0.5 2.5) 1.5))`},
+    {"func", `(do (defn f [x] (+ x 1)) (for [i (range 1000)] (f 2)))`},
+}
+
+func BenchmarkNumeric(b *testing.B) {
+    for _, prog := range NUMERIC_PROGS {
+        node := parse_prog(prog.code)
+        b.Run("eval/" + prog.name, func(b *testing.B) {
+            env := StandardEnv()
+            b.ReportAllocs()
+            for i := 0; i < b.N; i ++ {
+                Eval(node, env)
+            }
+        })
+        code := Compile(node)
+        b.Run("vm/" + prog.name, func(b *testing.B) {
+            env := StandardEnv()
+            b.ReportAllocs()
+            for i := 0; i < b.N; i ++ {
+                Run(code, env)
+            }
+        })
+    }
+}
@@ -0,0 +1,171 @@
+package eval
+
+import (
+    "fmt"
+    "io"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The profiler times the calls of the functions and primitives of an
+// evaluation, including the calls that primitives make, like of the
+// functions given to pmap or swap!. The time of a call counts as inclusive
+// for the function (once, even if it's recursive), and as exclusive without
+// the time of the calls it makes. The stack of calls is kept along the call
+// chain, so each goroutine has its own, under the call that started it; as
+// goroutines run side by side, the calls of a primitive like pmap can take
+// longer than it, leaving it no exclusive time.
+type Profiler struct {
+    lock    sync.Mutex
+    entries map[string]*ProfileEntry
+    names   map[*Object]string      // Of the primitives seen
+    folded  map[string]time.Duration    // Exclusive time of each stack, like "f;g;h"
+}
+
+// The time spent in a function
+type ProfileEntry struct {
+    Name        string
+    Calls       int
+    Inclusive   time.Duration
+    Exclusive   time.Duration
+}
+
+// A call on the stack of a call chain
+type prof_frame struct {
+    name    string
+    start   time.Time
+    inner   time.Duration       // Spent in the calls it made; under the profiler's lock
+    outer   *prof_frame
+}
+
+func NewProfiler() *Profiler {
+    return &Profiler{entries: make(map[string]*ProfileEntry), names: make(map[*Object]string),
+        folded: make(map[string]time.Duration)}
+}
+
+// The name of fun: the name a function was defined with, or the global a
+// primitive is bound to
+func (p * Profiler) name(fun *Object, it *Interpreter) string {
+    if fun.typ == OBJECT_FUNC {
+        if name := fun.val.(*Func).name; name != "" {
+            return name
+        }
+        return "fn"
+    }
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    name, ok := p.names[fun]
+    if ! ok {
+        name = "prim"
+        for _, sym := range it.Env.global.syms() {
+            if val, _ := it.Env.global.lookup(sym); val == fun {
+                name = sym.Name()
+                break
+            }
+        }
+        p.names[fun] = name
+    }
+    return name
+}
+
+// Calls fun from the frame caller, timing it
+func (p * Profiler) call(fun *Object, args []*Object, caller *Env) *Object {
+    frame := &prof_frame{name: p.name(fun, caller.it), outer: caller.prof}
+    inner := *caller        // The same frame, one profiled call deeper
+    inner.prof = frame
+    frame.start = time.Now()
+    defer p.end(frame)
+    return call_direct(fun, args, &inner)
+}
+
+// Ends a call, when it returns or raises
+func (p * Profiler) end(frame *prof_frame) {
+    elapsed := time.Since(frame.start)
+    names := []string{}
+    recursive := false
+    for f := frame; f != nil; f = f.outer {
+        names = append(names, f.name)
+        recursive = recursive || f != frame && f.name == frame.name
+    }
+    for i, j := 0, len(names) - 1; i < j; i, j = i + 1, j - 1 {
+        names[i], names[j] = names[j], names[i]
+    }
+
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    exclusive := elapsed - frame.inner
+    if exclusive < 0 {      // Its calls ran side by side
+        exclusive = 0
+    }
+    p.folded[strings.Join(names, ";")] += exclusive
+    if frame.outer != nil {
+        frame.outer.inner += elapsed
+    }
+
+    entry := p.entries[frame.name]
+    if entry == nil {
+        entry = &ProfileEntry{Name: frame.name}
+        p.entries[frame.name] = entry
+    }
+    entry.Calls ++
+    entry.Exclusive += exclusive
+    if ! recursive {        // The outermost call of a recursion
+        entry.Inclusive += elapsed
+    }
+}
+
+// The functions called, the slowest (in exclusive time) first
+func (p * Profiler) Entries() []ProfileEntry {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    entries := make([]ProfileEntry, 0, len(p.entries))
+    for _, entry := range p.entries {
+        entries = append(entries, *entry)
+    }
+    sort.Slice(entries, func(i, j int) bool {
+        a, b := entries[i], entries[j]
+        if a.Exclusive != b.Exclusive {
+            return a.Exclusive > b.Exclusive
+        }
+        return a.Name < b.Name
+    })
+    return entries
+}
+
+// Writes a table of the entries
+func (p * Profiler) Report(w io.Writer) {
+    var total time.Duration
+    entries := p.Entries()
+    for _, entry := range entries {
+        total += entry.Exclusive
+    }
+    fmt.Fprintf(w, "%12s %6s %12s %10s  %s\n", "exclusive", "%", "inclusive", "calls", "function")
+    for _, entry := range entries {
+        percent := 0.0
+        if total > 0 {
+            percent = 100 * float64(entry.Exclusive) / float64(total)
+        }
+        fmt.Fprintf(w, "%12v %6.2f %12v %10d  %s\n", entry.Exclusive, percent, entry.Inclusive, entry.Calls, entry.Name)
+    }
+}
+
+// Writes the stacks in the folded format of flame graph tools, like
+// flamegraph.pl: a line of the functions separated by ';' and the exclusive
+// time in microseconds for each stack
+func (p * Profiler) WriteFolded(w io.Writer) error {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    stacks := make([]string, 0, len(p.folded))
+    for stack := range p.folded {
+        stacks = append(stacks, stack)
+    }
+    sort.Strings(stacks)
+    for _, stack := range stacks {
+        if _, err := fmt.Fprintf(w, "%s %d\n", stack, p.folded[stack].Microseconds()); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,93 @@
+package eval
+
+import (
+    "bytes"
+    "reflect"
+    "sort"
+    "strings"
+    "testing"
+    "time"
+)
+
+// The number of calls of each function profiled, and the stacks of the
+// folded output
+func profiled(t *testing.T, p *Profiler) (map[string]int, []string) {
+    t.Helper()
+    calls := make(map[string]int)
+    for _, entry := range p.Entries() {
+        calls[entry.Name] = entry.Calls
+        if entry.Inclusive < entry.Exclusive {
+            t.Errorf("%s: inclusive time %v is less than exclusive time %v", entry.Name, entry.Inclusive, entry.Exclusive)
+        }
+    }
+    var out bytes.Buffer
+    p.WriteFolded(&out)
+    stacks := []string{}
+    for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+        stacks = append(stacks, line[:strings.LastIndex(line, " ")])
+    }
+    return calls, stacks
+}
+
+func TestProfiler(t *testing.T) {
+    it := limited(Limits{})
+    it.Eval(`(defn twice [x] (+ x x)) (defn quad [x] (twice (twice x)))`)
+    p := NewProfiler()
+    it.Profiler = p
+    check_evals(t, it, []eval_test{
+        {`(quad 1)`, "4"},
+        {`(down 3)`, "3"},
+        {`(swap! (atom 1) twice)`, "2"},
+    })
+    calls, stacks := profiled(t, p)
+    for name, want := range map[string]int{"quad": 1, "twice": 3, "+": 6, "down": 4, "pos?": 4, "swap!": 1} {
+        if calls[name] != want {
+            t.Errorf("%s was called %d times, want %d", name, calls[name], want)
+        }
+    }
+    want := []string{"atom", "down", "down;+", "down;-", "down;down", "down;down;+", "down;down;-", "down;down;down",
+        "down;down;down;+", "down;down;down;-", "down;down;down;down", "down;down;down;down;pos?",
+        "down;down;down;pos?", "down;down;pos?", "down;pos?", "quad", "quad;twice", "quad;twice;+", "swap!", "swap!;twice", "swap!;twice;+"}
+    if ! reflect.DeepEqual(stacks, want) {
+        t.Errorf("Stacks %q, want %q", stacks, want)
+    }
+
+    var report bytes.Buffer
+    p.Report(&report)
+    lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+    if len(lines) != len(calls) + 1 || ! strings.Contains(lines[0], "exclusive") {
+        t.Errorf("Report:\n%s", report.String())
+    }
+}
+
+// The calls of each goroutine are on their own stack, under the call that
+// started it, with the VM too
+func TestProfileGoroutines(t *testing.T) {
+    for _, vm := range []bool{false, true} {
+        it := NewInterpreter()
+        it.VM = vm
+        it.Parallelism = 4
+        it.Eval(`(defn twice [x] (+ x x))`)
+        p := NewProfiler()
+        it.Profiler = p
+        check_evals(t, it, []eval_test{
+            {`(pmap twice (range 100))`, "[0 2 4 ..."},
+            {`(deref (future twice 1))`, "2"},
+            {`(let [ch (chan)] (go (fn [] (send ch (twice 2)))) (recv ch))`, "4"},
+        })
+        calls, stacks := profiled(t, p)
+        for i := 0; i < 100 && calls["fn"] == 0; i ++ {     // The goroutine of go may not have returned yet
+            time.Sleep(10 * time.Millisecond)
+            calls, stacks = profiled(t, p)
+        }
+        if calls["twice"] != 102 {
+            t.Errorf("twice was called %d times", calls["twice"])
+        }
+        want := []string{"chan", "deref", "future", "future;twice", "future;twice;+", "go", "go;fn", "go;fn;send",
+            "go;fn;twice", "go;fn;twice;+", "pmap", "pmap;twice", "pmap;twice;+", "range", "recv"}
+        sort.Strings(stacks)
+        if ! reflect.DeepEqual(stacks, want) {
+            t.Errorf("vm %v: stacks %q, want %q", vm, stacks, want)
+        }
+    }
+}
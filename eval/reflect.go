@@ -0,0 +1,378 @@
+package eval
+
+import (
+    "context"
+    "fmt"
+    "reflect"
+    "strings"
+)
+
+// Conversions between Gysp objects and Go values by reflection, so that Go
+// functions can be called from Gysp
+
+var (
+    OBJECT_PTR_TYPE = reflect.TypeOf((*Object)(nil))
+    ERROR_TYPE = reflect.TypeOf((*error)(nil)).Elem()
+    CONTEXT_TYPE = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// A field of a struct as seen from Gysp
+type struct_field struct {
+    name        string      // From the gysp tag, or the kebab-case Go name
+    index       []int
+    omitempty   bool
+}
+
+// The fields of a struct that Gysp can see: the exported ones, except those
+// tagged `gysp:"-"`; the fields of embedded structs are included as if they
+// were the struct's own
+func struct_fields(t reflect.Type) []struct_field {
+    fields := make([]struct_field, 0, t.NumField())
+    for i := 0; i < t.NumField(); i ++ {
+        f := t.Field(i)
+        tag := f.Tag.Get("gysp")
+        if tag == "-" {
+            continue
+        }
+        opts := strings.Split(tag, ",")
+        name := opts[0]
+        if name == "" && f.Anonymous && f.Type.Kind() == reflect.Struct {
+            for _, sub := range struct_fields(f.Type) {
+                sub.index = append([]int{i}, sub.index...)
+                fields = append(fields, sub)
+            }
+            continue
+        }
+        if f.PkgPath != "" {    // Unexported
+            continue
+        }
+        if name == "" {
+            name = KebabCase(f.Name)
+        }
+        field := struct_field{name, []int{i}, false}
+        for _, opt := range opts[1:] {
+            if opt == "omitempty" {
+                field.omitempty = true
+            }
+        }
+        fields = append(fields, field)
+    }
+    return fields
+}
+
+func to_go_err(o *Object, t reflect.Type) {
+    panic(fmt.Sprintf("Cannot convert %v to Go %v!", o.typ, t))
+}
+
+// The Go value of o in its natural type: nil, bool, int, float64,
+// complex128, string, []interface{}, map[string]interface{} (if all the keys
+// are strings) or map[interface{}]interface{}; Go objects are unwrapped and
+// functions stay *Objects
+func natural(o *Object) interface{} {
+    switch o.typ {
+    case OBJECT_NIL:
+        return nil
+    case OBJECT_BOOL, OBJECT_INT, OBJECT_FLOAT, OBJECT_CMPLX, OBJECT_STR, OBJECT_GO:
+        return o.val
+    case OBJECT_LIST:
+        items := o.val.([]*Object)
+        list := make([]interface{}, len(items))
+        for i, item := range items {
+            list[i] = natural(item)
+        }
+        return list
+    case OBJECT_DICT:
+        dict := o.val.(map[Object]*Object)
+        strs := make(map[string]interface{}, len(dict))
+        for k, v := range dict {
+            if k.typ != OBJECT_STR {
+                strs = nil
+                break
+            }
+            strs[k.val.(string)] = natural(v)
+        }
+        if strs != nil {
+            return strs
+        }
+        any := make(map[interface{}]interface{}, len(dict))
+        for k, v := range dict {
+            key := k
+            any[natural(&key)] = natural(v)
+        }
+        return any
+    }
+    return o
+}
+
+// Converts o to a Go value of type t
+func to_go(o *Object, t reflect.Type) reflect.Value {
+    if t == OBJECT_PTR_TYPE {
+        return reflect.ValueOf(o)
+    }
+    if o.typ == OBJECT_GO {
+        if v := reflect.ValueOf(o.val); v.Type().AssignableTo(t) {
+            return v
+        }
+        to_go_err(o, t)
+    }
+
+    val := reflect.New(t).Elem()
+    switch t.Kind() {
+    case reflect.Interface:
+        if nat := natural(o); nat != nil {
+            if ! reflect.TypeOf(nat).AssignableTo(t) {
+                to_go_err(o, t)
+            }
+            val.Set(reflect.ValueOf(nat))
+        }
+    case reflect.Bool:
+        switch o {
+        case GYSP_TRUE:
+            val.SetBool(true)
+        case GYSP_FALSE, GYSP_NIL:
+        default:
+            to_go_err(o, t)
+        }
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        if o.typ != OBJECT_INT {
+            to_go_err(o, t)
+        }
+        i := int64(o.val.(int))
+        if val.OverflowInt(i) {
+            panic(fmt.Sprintf("%d overflows Go %v!", i, t))
+        }
+        val.SetInt(i)
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+        if o.typ != OBJECT_INT {
+            to_go_err(o, t)
+        }
+        i := o.val.(int)
+        if i < 0 || val.OverflowUint(uint64(i)) {
+            panic(fmt.Sprintf("%d overflows Go %v!", i, t))
+        }
+        val.SetUint(uint64(i))
+    case reflect.Float32, reflect.Float64:
+        switch o.typ {
+        case OBJECT_INT, OBJECT_FLOAT:
+            val.SetFloat(to_float(o).val.(float64))
+        default:
+            to_go_err(o, t)
+        }
+    case reflect.Complex64, reflect.Complex128:
+        switch o.typ {
+        case OBJECT_INT, OBJECT_FLOAT, OBJECT_CMPLX:
+            val.SetComplex(to_cmplx(o).val.(complex128))
+        default:
+            to_go_err(o, t)
+        }
+    case reflect.String:
+        if o.typ != OBJECT_STR {
+            to_go_err(o, t)
+        }
+        val.SetString(o.val.(string))
+    case reflect.Slice:
+        if o == GYSP_NIL {
+            break
+        }
+        if o.typ != OBJECT_LIST {
+            to_go_err(o, t)
+        }
+        items := o.val.([]*Object)
+        val.Set(reflect.MakeSlice(t, len(items), len(items)))
+        for i, item := range items {
+            val.Index(i).Set(to_go(item, t.Elem()))
+        }
+    case reflect.Array:
+        if o.typ != OBJECT_LIST || len(o.val.([]*Object)) != t.Len() {
+            to_go_err(o, t)
+        }
+        for i, item := range o.val.([]*Object) {
+            val.Index(i).Set(to_go(item, t.Elem()))
+        }
+    case reflect.Map:
+        if o == GYSP_NIL {
+            break
+        }
+        if o.typ != OBJECT_DICT {
+            to_go_err(o, t)
+        }
+        dict := o.val.(map[Object]*Object)
+        val.Set(reflect.MakeMapWithSize(t, len(dict)))
+        for _, k := range sorted_keys(dict) {
+            key := k
+            val.SetMapIndex(to_go(&key, t.Key()), to_go(dict[k], t.Elem()))
+        }
+    case reflect.Struct:
+        if o.typ != OBJECT_DICT {
+            to_go_err(o, t)
+        }
+        fields := make(map[string]struct_field)
+        for _, field := range struct_fields(t) {
+            fields[field.name] = field
+        }
+        dict := o.val.(map[Object]*Object)
+        for _, k := range sorted_keys(dict) {     // So that errors are the same every time
+            if k.typ != OBJECT_STR {
+                panic(fmt.Sprintf("Field names of Go %v must be strings!", t))
+            }
+            field, ok := fields[k.val.(string)]
+            if ! ok {
+                panic(fmt.Sprintf("Go %v has no field '%s'!", t, k.val.(string)))
+            }
+            dest := val.FieldByIndex(field.index)
+            dest.Set(to_go(dict[k], dest.Type()))
+        }
+    case reflect.Ptr:
+        if o == GYSP_NIL {
+            break
+        }
+        val.Set(reflect.New(t.Elem()))
+        val.Elem().Set(to_go(o, t.Elem()))
+    default:
+        to_go_err(o, t)
+    }
+    return val
+}
+
+// Converts a Go value to an object; functions are wrapped as primitives
+// called with the context of it (which may be nil). Pointers to structs and
+// values that can't be converted, like channels, are kept as Go objects,
+// unless deep is set (for marshalling), in which case pointers are followed.
+func from_go(v reflect.Value, it *Interpreter, deep bool) *Object {
+    if ! v.IsValid() {
+        return GYSP_NIL
+    }
+    if v.Type() == OBJECT_PTR_TYPE {
+        if v.IsNil() {
+            return GYSP_NIL
+        }
+        return v.Interface().(*Object)
+    }
+
+    switch v.Kind() {
+    case reflect.Bool:
+        return NewBool(v.Bool())
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return NewInt(int(v.Int()))
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+        return NewInt(int(v.Uint()))
+    case reflect.Float32, reflect.Float64:
+        return NewObject(OBJECT_FLOAT, v.Float())
+    case reflect.Complex64, reflect.Complex128:
+        return NewObject(OBJECT_CMPLX, v.Complex())
+    case reflect.String:
+        it.check_items(v.Len())
+        return NewStr(v.String())
+    case reflect.Slice, reflect.Array:
+        if v.Kind() == reflect.Slice && v.IsNil() {
+            return GYSP_NIL
+        }
+        it.check_items(v.Len())
+        list := make([]*Object, v.Len())
+        for i := range list {
+            list[i] = from_go(v.Index(i), it, deep)
+        }
+        return NewObject(OBJECT_LIST, list)
+    case reflect.Map:
+        if v.IsNil() {
+            return GYSP_NIL
+        }
+        it.check_items(v.Len())
+        dict := make(map[Object]*Object, v.Len())
+        for iter := v.MapRange(); iter.Next(); {
+            dict[*from_go(iter.Key(), it, deep)] = from_go(iter.Value(), it, deep)
+        }
+        return NewObject(OBJECT_DICT, dict)
+    case reflect.Struct:
+        fields := struct_fields(v.Type())
+        dict := make(map[Object]*Object, len(fields))
+        for _, field := range fields {
+            val := v.FieldByIndex(field.index)
+            if field.omitempty && val.IsZero() {
+                continue
+            }
+            dict[*NewStr(field.name)] = from_go(val, it, deep)
+        }
+        return NewObject(OBJECT_DICT, dict)
+    case reflect.Ptr, reflect.Interface:
+        if v.IsNil() {
+            return GYSP_NIL
+        }
+        if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct && ! deep {
+            return NewGo(v.Interface())
+        }
+        return from_go(v.Elem(), it, deep)
+    case reflect.Func:
+        if v.IsNil() {
+            return GYSP_NIL
+        }
+        return wrap_func(v, it)
+    }
+    if deep || ! v.CanInterface() {
+        panic(fmt.Sprintf("Cannot convert Go %v to a Gysp value!", v.Type()))
+    }
+    return NewGo(v.Interface())
+}
+
+// Wraps a Go function as a primitive. A leading context.Context parameter
+// gets the context of it, and a trailing error result is raised as an
+// exception; multiple results are returned as a list.
+func wrap_func(fn reflect.Value, it *Interpreter) *Object {
+    t := fn.Type()
+    first := 0      // Where the Gysp arguments start
+    if t.NumIn() > 0 && t.In(0) == CONTEXT_TYPE {
+        first = 1
+    }
+    nout := t.NumOut()
+    with_err := nout > 0 && t.Out(nout - 1) == ERROR_TYPE
+    if with_err {
+        nout --
+    }
+
+    return NewPrim(func (args []*Object) *Object {
+        nparams := t.NumIn() - first
+        if t.IsVariadic() {
+            if len(args) < nparams - 1 {
+                panic(fmt.Sprintf("Expected at least %d arguments but %d were given", nparams - 1, len(args)))
+            }
+        } else if len(args) != nparams {
+            panic(fmt.Sprintf("Expected %d arguments but %d were given", nparams, len(args)))
+        }
+
+        in := make([]reflect.Value, 0, first + len(args))
+        if first > 0 {
+            ctx := context.Background()
+            if it != nil {
+                ctx = it.context()
+            }
+            in = append(in, reflect.ValueOf(&ctx).Elem())
+        }
+        for i, arg := range args {
+            var param reflect.Type
+            if t.IsVariadic() && first + i >= t.NumIn() - 1 {
+                param = t.In(t.NumIn() - 1).Elem()
+            } else {
+                param = t.In(first + i)
+            }
+            in = append(in, to_go(arg, param))
+        }
+
+        out := fn.Call(in)
+        if with_err {
+            if err := out[nout]; ! err.IsNil() {
+                raise(err.Interface().(error))
+            }
+        }
+        switch nout {
+        case 0:
+            return GYSP_NIL
+        case 1:
+            return from_go(out[0], it, false)
+        }
+        list := make([]*Object, nout)
+        for i := range list {
+            list[i] = from_go(out[i], it, false)
+        }
+        return NewObject(OBJECT_LIST, list)
+    })
+}
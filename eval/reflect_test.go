@@ -0,0 +1,150 @@
+package eval
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "testing"
+)
+
+// The value of code, printed like by the REPL, or "error: " and the error
+func eval_string(it *Interpreter, code string) string {
+    val, err := it.Eval(code)
+    if err != nil {
+        return "error: " + err.Error()
+    }
+    return val.GoString()
+}
+
+// Code and what eval_string() gives for it; a result ending with "..."
+// only has to start with the rest
+type eval_test struct {
+    code    string
+    want    string
+}
+
+func check_evals(t *testing.T, it *Interpreter, tests []eval_test) {
+    t.Helper()
+    for _, test := range tests {
+        got := eval_string(it, test.code)
+        if want := strings.TrimSuffix(test.want, "..."); want != test.want && strings.HasPrefix(got, want) {
+            continue
+        }
+        if got != test.want {
+            t.Errorf("%s = %s, want %s", test.code, got, test.want)
+        }
+    }
+}
+
+var ERR_DIV = errors.New("Division by zero!")
+
+func define_funcs(it *Interpreter) {
+    it.Define("add", func (a int, b float64) float64 { return float64(a) + b })
+    it.Define("small", func (i int8) int8 { return i })
+    it.Define("count", func (n uint) uint { return n })
+    it.Define("shout", func (s string, loud bool) string {
+        if loud {
+            return strings.ToUpper(s) + "!"
+        }
+        return s
+    })
+    it.Define("sum", func (xs []int) int {
+        n := 0
+        for _, x := range xs {
+            n += x
+        }
+        return n
+    })
+    it.Define("keys", func (m map[string]int) int { return len(m) })
+    it.Define("same", func (o *Object) *Object { return o })
+    it.Define("join", func (sep string, xs ...int) string {
+        strs := make([]string, len(xs))
+        for i, x := range xs {
+            strs[i] = string(rune('0' + x))
+        }
+        return strings.Join(strs, sep)
+    })
+    it.Define("div", func (a, b int) (int, error) {
+        if b == 0 {
+            return 0, ERR_DIV
+        }
+        return a / b, nil
+    })
+    it.Define("check", func (ok bool) error {
+        if ! ok {
+            return ERR_DIV
+        }
+        return nil
+    })
+    it.Define("divmod", func (a, b int) (int, int) { return a / b, a % b })
+    it.Define("ctx?", func (ctx context.Context, x int) bool { return ctx != nil && ctx.Err() == nil })
+    it.Define("nth", func (xs []int, i int) int { return xs[i] })
+    it.Define("bug", func () { panic("Internal: broken!") })
+    it.Define("host-panic", func () { panic(errors.New("host broke")) })
+}
+
+func TestDefineArgs(t *testing.T) {
+    it := NewInterpreter()
+    define_funcs(it)
+    check_evals(t, it, []eval_test{
+        {`(add 1 2.5)`, "3.5"},
+        {`(add 1 2)`, "3"},     // Ints convert to floats
+        {`(add 1.5 2)`, "error: Cannot convert float to Go int!"},
+        {`(add 1)`, "error: Expected 2 arguments but 1 were given"},
+        {`(small 100)`, "100"},
+        {`(small 300)`, "error: 300 overflows Go int8!"},
+        {`(count -1)`, "error: -1 overflows Go uint!"},
+        {`(shout "hi" true)`, `"HI!"`},
+        {`(shout "hi" nil)`, `"hi"`},
+        {`(shout 1 true)`, "error: Cannot convert int to Go string!"},
+        {`(sum [1 2 3])`, "6"},
+        {`(sum nil)`, "0"},
+        {`(sum [1 "2"])`, "error: Cannot convert string to Go int!"},
+        {`(keys {"a" 1 "b" 2})`, "2"},
+        {`(keys [1])`, "error: Cannot convert list to Go map[string]int!"},
+        {`(same [1 "a"])`, `[1 a]`},
+        {`(divmod 7 2)`, "[3 1]"},
+        {`(ctx? 1)`, "true"},
+    })
+}
+
+func TestDefineVariadic(t *testing.T) {
+    it := NewInterpreter()
+    define_funcs(it)
+    check_evals(t, it, []eval_test{
+        {`(join "," 1 2 3)`, `"1,2,3"`},
+        {`(join ",")`, `""`},
+        {`(join)`, "error: Expected at least 1 arguments but 0 were given"},
+        {`(join "," 1 "2")`, "error: Cannot convert string to Go int!"},
+    })
+}
+
+func TestDefineError(t *testing.T) {
+    it := NewInterpreter()
+    define_funcs(it)
+    check_evals(t, it, []eval_test{
+        {`(div 7 2)`, "3"},
+        {`(div 1 0)`, "error: Division by zero!"},
+        {`(try (div 1 0) (catch e [e]))`, `[Division by zero!]`},
+        {`(check true)`, "nil"},
+        {`(try (check nil) (catch e e))`, `"Division by zero!"`},
+    })
+    _, err := it.Eval(`(div 1 0)`)
+    if ! errors.Is(err, ERR_DIV) {
+        t.Errorf("Error %v doesn't wrap the Go error", err)
+    }
+}
+
+// Bugs in Go code aren't exceptions
+func TestUncatchable(t *testing.T) {
+    it := NewInterpreter()
+    define_funcs(it)
+    check_evals(t, it, []eval_test{
+        {`(try (nth [1] 5) (catch e "caught"))`, "error: runtime error: index out of range..."},
+        {`(try (bug) (catch e "caught"))`, "error: Internal: broken!"},
+        {`(try (host-panic) (catch e "caught"))`, "error: host broke"},
+        {`(try (nth [1] 0) (catch e "caught"))`, "1"},
+        {`(try (throw 1) (catch e e))`, "1"},
+        {`(try (+ 1 "a") (catch e "caught"))`, `"caught"`},
+    })
+}
@@ -0,0 +1,243 @@
+package eval
+
+import (
+    "github.com/crides/gysp/parse"
+)
+
+// Tree of Node --- Resolve --> Tree of Node with LocalNodes
+//
+// Literals are converted to objects (as WrapNodes) once here. Every symbol
+// bound by let, for, do, fn or defn is replaced with a LocalNode which says
+// how many frames up the variable is, and in which slot, so that eval()
+// doesn't have to look it up by name. Symbols that aren't bound
+// locally stay SymNodes and are looked up in the global environment when
+// evaluated, so globals can be redefined at any time.
+
+// Only made by the resolver, so it's a kind of node of eval's own
+const NODE_LOCAL = parse.NODE_WRAP + 1
+
+type LocalNode struct {
+    Sym     parse.Sym
+    Depth   int         // Number of frames to go up
+    Slot    int
+    Pos     parse.Pos   // Of the symbol
+}
+
+func (ln * LocalNode) NodeTyp() parse.NodeType {
+    return NODE_LOCAL
+}
+
+func (ln * LocalNode) String() string {
+    return ln.Sym.Name()
+}
+
+// The names of a frame at resolution time
+type scope struct {
+    syms    []parse.Sym
+    next    *scope
+}
+
+// Adds a name to the scope; names are only added once, like Env.bind()
+func (s * scope) add(sym parse.Sym) {
+    check_bindable(sym)
+    for _, name := range s.syms {
+        if name == sym {
+            return
+        }
+    }
+    s.syms = append(s.syms, sym)
+}
+
+func (s * scope) lookup(sym parse.Sym) (int, int, bool) {
+    for depth := 0; s != nil; s, depth = s.next, depth + 1 {
+        for slot, name := range s.syms {
+            if name == sym {
+                return depth, slot, true
+            }
+        }
+    }
+    return 0, 0, false
+}
+
+// The scopes of the frames from env up to the global environment
+func env_scope(env *Env) *scope {
+    if env == nil || env.global != nil {
+        return nil
+    }
+    return &scope{append([]parse.Sym(nil), env.names...), env_scope(env.next)}
+}
+
+// Resolves the local variables of a tree to be evaluated in env. The tree
+// isn't modified; the parts with locals are copied.
+func Resolve(node parse.Node, env *Env) parse.Node {
+    return resolve(node, env_scope(env))
+}
+
+func resolve_list(nodes []parse.Node, sc *scope) []parse.Node {
+    list := make([]parse.Node, len(nodes))
+    for i, node := range nodes {
+        list[i] = resolve(node, sc)
+    }
+    return list
+}
+
+// The names of a list of symbols (like the parameters of fn), or nil if it's
+// not one
+func sym_list(node parse.Node) []parse.Sym {
+    list, ok := node.(*parse.ListNode)
+    if ! ok {
+        return nil
+    }
+    syms := make([]parse.Sym, len(list.List))
+    for i, item := range list.List {
+        sym, ok := item.(*parse.SymNode)
+        if ! ok {
+            return nil
+        }
+        syms[i] = sym.Sym
+    }
+    return syms
+}
+
+// Resolves the values of a binding list like [a 1 b 2] in sc, keeping the
+// names; returns the new list and the names, or nil if it's malformed
+func resolve_bindings(node parse.Node, sc *scope) (*parse.ListNode, []parse.Sym) {
+    list, ok := node.(*parse.ListNode)
+    if ! ok || len(list.List) % 2 != 0 {
+        return nil, nil
+    }
+    res := &parse.ListNode{List: make([]parse.Node, len(list.List)), Pos: list.Pos}
+    syms := make([]parse.Sym, 0, len(list.List) / 2)
+    for i := 0; i < len(list.List); i += 2 {
+        sym, ok := list.List[i].(*parse.SymNode)
+        if ! ok {
+            return nil, nil
+        }
+        res.List[i], res.List[i + 1] = sym, resolve(list.List[i + 1], sc)
+        syms = append(syms, sym.Sym)
+    }
+    return res, syms
+}
+
+// Resolves nodes in a new frame with the names syms
+func resolve_frame(nodes []parse.Node, syms []parse.Sym, sc *scope) []parse.Node {
+    inner := &scope{nil, sc}
+    for _, sym := range syms {
+        inner.add(sym)
+    }
+    return resolve_list(nodes, inner)
+}
+
+// Resolves a reference that can be set
+func resolve_target(node parse.Node, sc *scope) parse.Node {
+    switch node.(type) {
+    case *parse.SymNode, *LocalNode:
+        return resolve(node, sc)
+    }
+    return node
+}
+
+func resolve_call(n *parse.CallNode, sc *scope) parse.Node {
+    args := n.Arglist
+    sym, ok := n.Fun.(*parse.SymNode)
+    if ok && (sym.Name == "quote" || sym.Name == "quasiquote") {    // Data, not code
+        return n
+    }
+    if ! ok || special(sym.Sym) == nil {
+        return &parse.CallNode{Fun: resolve(n.Fun, sc), Arglist: resolve_list(args, sc), Pos: n.Pos}
+    }
+
+    switch sym.Name {
+    case "let", "for":      // (let [name val ...] body...)
+        if len(args) < 1 {
+            break
+        }
+        bindings, syms := resolve_bindings(args[0], sc)
+        if bindings == nil {
+            break
+        }
+        body := resolve_frame(args[1:], syms, sc)
+        return &parse.CallNode{Fun: n.Fun, Arglist: append([]parse.Node{bindings}, body...), Pos: n.Pos}
+    case "do":              // (do body...)
+        return &parse.CallNode{Fun: n.Fun, Arglist: resolve_frame(args, nil, sc), Pos: n.Pos}
+    case "fn":              // (fn [params] body...)
+        if len(args) < 1 || sym_list(args[0]) == nil {
+            break
+        }
+        body := resolve_frame(args[1:], sym_list(args[0]), sc)
+        return &parse.CallNode{Fun: n.Fun, Arglist: append([]parse.Node{args[0]}, body...), Pos: n.Pos}
+    case "defn":            // (defn name [params] body...)
+        if len(args) < 2 || sym_list(args[1]) == nil {
+            break
+        }
+        body := resolve_frame(args[2:], sym_list(args[1]), sc)
+        return &parse.CallNode{Fun: n.Fun, Arglist: append([]parse.Node{resolve_target(args[0], sc), args[1]}, body...), Pos: n.Pos}
+    case "try":             // (try body... (catch e handler...))
+        body, clause := try_clauses(args)
+        res := resolve_list(body, sc)
+        if clause != nil {
+            var syms []parse.Sym
+            if len(clause.Arglist) > 0 {
+                syms = sym_list(&parse.ListNode{List: clause.Arglist[:1]})
+            }
+            if syms == nil {
+                break
+            }
+            handler := resolve_frame(clause.Arglist[1:], syms, sc)
+            res = append(res, &parse.CallNode{Fun: clause.Fun, Arglist: append(clause.Arglist[:1:1], handler...), Pos: clause.Pos})
+        }
+        return &parse.CallNode{Fun: n.Fun, Arglist: res, Pos: n.Pos}
+    case "set":             // (set ref val ...)
+        res := make([]parse.Node, len(args))
+        for i, arg := range args {
+            if i % 2 == 0 {
+                res[i] = resolve_target(arg, sc)
+            } else {
+                res[i] = resolve(arg, sc)
+            }
+        }
+        return &parse.CallNode{Fun: n.Fun, Arglist: res, Pos: n.Pos}
+    }
+    // Malformed forms are left for the special forms to complain about
+    return &parse.CallNode{Fun: n.Fun, Arglist: resolve_list(args, sc), Pos: n.Pos}
+}
+
+// Expands a call of a macro in env. The arguments were resolved where the
+// call is, but the expansion may put them in frames of its own, so it is
+// resolved again in the scope of env.
+func expand(macro func([]parse.Node, *Env) parse.Node, args []parse.Node, env *Env) parse.Node {
+    node := macro(args, env)
+    if _, ok := node.(*WrapNode); ok {      // Evaluated already
+        return node
+    }
+    return resolve(node, env_scope(env))
+}
+
+func resolve(node parse.Node, sc *scope) parse.Node {
+    switch n := node.(type) {
+    case *parse.SymNode:
+        if depth, slot, ok := sc.lookup(n.Sym); ok {
+            return &LocalNode{n.Sym, depth, slot, n.Pos}
+        }
+        return n
+    case *LocalNode:        // Resolved before, maybe in another scope (see expand())
+        if depth, slot, ok := sc.lookup(n.Sym); ok {
+            return &LocalNode{n.Sym, depth, slot, n.Pos}
+        }
+        return &parse.SymNode{Name: n.Sym.Name(), Sym: n.Sym, Pos: n.Pos}
+    case *parse.LiteralNode:
+        return &WrapNode{literal(n), n.Pos}
+    case *parse.ListNode:
+        return &parse.ListNode{List: resolve_list(n.List, sc), Pos: n.Pos}
+    case *parse.DictNode:
+        dn := parse.NewDictNode()
+        for _, k := range n.Keys {
+            dn.Set(resolve(k, sc), resolve(n.Dict[k], sc))
+        }
+        dn.Pos = n.Pos
+        return dn
+    case *parse.CallNode:
+        return resolve_call(n, sc)
+    }
+    return node
+}
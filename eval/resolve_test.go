@@ -0,0 +1,110 @@
+package eval
+
+import (
+    "fmt"
+    "testing"
+    "github.com/crides/gysp/parse"
+)
+
+// The LocalNodes of a resolved tree, in order, like "a 1:0"
+func locals(node parse.Node) []string {
+    res := make([]string, 0)
+    var walk func(parse.Node)
+    walk = func(node parse.Node) {
+        switch n := node.(type) {
+        case *LocalNode:
+            res = append(res, fmt.Sprintf("%s %d:%d", n.Sym.Name(), n.Depth, n.Slot))
+        case *parse.ListNode:
+            for _, item := range n.List {
+                walk(item)
+            }
+        case *parse.CallNode:
+            walk(n.Fun)
+            for _, arg := range n.Arglist {
+                walk(arg)
+            }
+        }
+    }
+    walk(node)
+    return res
+}
+
+var RESOLVE_TESTS = []struct {
+    code    string
+    locals  []string        // As given by locals()
+    val     string
+}{
+    // Shadowing
+    {`(let [a 1] (let [a 2] a))`, []string{"a 0:0"}, "2"},
+    {`(let [a 1] (let [b 2] [a b]))`, []string{"a 1:0", "b 0:0"}, "[1 2]"},
+    {`(let [a 1] (let [a (+ a 1)] a))`, []string{"a 0:0", "a 0:0"}, "2"},     // The value is outside
+    {`(let [a 1] (fn [a] a) a)`, []string{"a 0:0", "a 0:0"}, "1"},
+    {`(defn f [x] (let [x (* x 2)] x)) (f 3)`, []string{"x 0:0", "x 0:0"}, "6"},
+
+    // Closures
+    {`(defn adder [n] (fn [x] (+ x n))) ((adder 1) 2)`, []string{"x 0:0", "n 1:0"}, "3"},
+    {`(defn counter [] (let [n 0] (fn [] (set n (+ n 1))))) (let [c (counter)] (c) (c))`,
+        []string{"n 1:0", "n 1:0", "c 0:0", "c 0:0"}, "2"},
+    // The loop variables are in one frame for the whole loop, so the
+    // closures made in it see their last values, like in Go before 1.22
+    {`(let [a nil b nil] (for [i [1 2]] (if a (set b (fn [] i)) (set a (fn [] i)))) [(a) (b)])`,
+        []string{"a 1:0", "b 1:1", "i 1:0", "a 1:0", "i 1:0", "a 0:0", "b 0:1"}, "[2 2]"},
+
+    // set on outer locals
+    {`(let [n 1] (let [m 2] (set n (+ n m))) n)`, []string{"n 1:0", "n 1:0", "m 0:0", "n 0:0"}, "3"},
+    {`(let [n 1] (do (set n 5)) n)`, []string{"n 1:0", "n 0:0"}, "5"},
+    {`(let [n 1] ((fn [] (set n 7))) n)`, []string{"n 1:0", "n 0:0"}, "7"},
+    {`(set g 1) (let [n 2] (set g (+ g n))) g`, []string{"n 0:0"}, "3"},     // Globals stay symbols
+}
+
+func TestResolve(t *testing.T) {
+    for _, test := range RESOLVE_TESTS {
+        node := Resolve(parse_prog(test.code), nil)
+        if got := locals(node); fmt.Sprint(got) != fmt.Sprint(test.locals) {
+            t.Errorf("%s: resolved to %v, want %v", test.code, got, test.locals)
+        }
+        if got := Eval(parse_prog(test.code), StandardEnv()).GoString(); got != test.val {
+            t.Errorf("%s = %s, want %s", test.code, got, test.val)
+        }
+        if got := Exec(parse_prog(test.code), StandardEnv()).GoString(); got != test.val {
+            t.Errorf("%s = %s on the VM, want %s", test.code, got, test.val)
+        }
+    }
+}
+
+// A macro that evaluates its argument in a frame of its own, where tmp is 0
+func wrap_macro(args []parse.Node, env *Env) parse.Node {
+    return &parse.CallNode{Fun: parse.NewSymNode("let"), Arglist: []parse.Node{
+        &parse.ListNode{List: []parse.Node{parse.NewSymNode("tmp"), parse.NewLiteralNode(0)}},
+        args[0],
+    }}
+}
+
+func TestResolveMacroArgs(t *testing.T) {
+    for _, test := range []struct{ code, val string }{
+        {`(let [a 5] (wrap a))`, "5"},
+        {`(let [a 5 b 6] (wrap [b a]))`, "[6 5]"},
+        {`(let [a 5] (wrap (let [b 1] (+ a b))))`, "6"},
+        {`(let [a 5] (wrap (fn [] a)))`, "<function>"},
+        {`(let [a 5] ((wrap (fn [] a))))`, "5"},
+        {`(let [tmp 5] (wrap tmp))`, "0"},      // Not hygienic
+        {`(defn f [x] (wrap (set x (+ x 1))) x) (f 1)`, "2"},
+    } {
+        for _, run := range []func(parse.Node, *Env) *Object{Eval, Exec} {
+            env := StandardEnv()
+            env.SetVarX("wrap", NewMacro(wrap_macro))
+            if got := run(parse_prog(test.code), env).GoString(); got != test.val {
+                t.Errorf("%s = %s, want %s", test.code, got, test.val)
+            }
+        }
+    }
+}
+
+func TestResolveKeepsTree(t *testing.T) {
+    prog := parse_prog(`(let [a 1] a)`)
+    before := prog.String()
+    Resolve(prog, nil)
+    if prog.String() != before {
+        t.Errorf("Resolve changed the tree: %s -> %s", before, prog.String())
+    }
+}
@@ -0,0 +1,207 @@
+package eval
+
+import (
+    "fmt"
+    "sort"
+    "github.com/crides/gysp/parse"
+)
+
+// Special forms are evaluated in place by eval() with their arguments
+// unevaluated. Unlike macros they aren't values in the environment, so they
+// can't be rebound or shadowed, and tools can tell them apart by name.
+type Special func(args []parse.Node, env *Env) *Object
+
+var SPECIAL_FORMS []Special     // Indexed by the symbol of the name
+
+// The special form named sym, or nil
+func special(sym parse.Sym) Special {
+    if int(sym) < len(SPECIAL_FORMS) {
+        return SPECIAL_FORMS[sym]
+    }
+    return nil
+}
+
+func IsSpecial(name string) bool {
+    return special(parse.Intern(name)) != nil
+}
+
+func SpecialForms() []string {  // Sorted names of the special forms
+    names := make([]string, 0)
+    for sym, form := range SPECIAL_FORMS {
+        if form != nil {
+            names = append(names, parse.Sym(sym).Name())
+        }
+    }
+    sort.Strings(names)
+    return names
+}
+
+func def_special(name string, form Special) {
+    sym := parse.Intern(name)
+    for int(sym) >= len(SPECIAL_FORMS) {
+        SPECIAL_FORMS = append(SPECIAL_FORMS, nil)
+    }
+    SPECIAL_FORMS[sym] = form
+}
+
+// Panics if sym can't be bound as a variable
+func check_bindable(sym parse.Sym) {
+    if special(sym) != nil {
+        panic(fmt.Sprintf("Cannot rebind special form '%s'!", sym.Name()))
+    }
+}
+
+// Evaluates nodes in env; returns the value of the last one, or nil
+func eval_body(nodes []parse.Node, env *Env) *Object {
+    if len(nodes) == 0 {
+        return GYSP_NIL
+    }
+    for i := 0; i < len(nodes) - 1; i ++ {
+        eval(nodes[i], env)
+    }
+    return eval(nodes[len(nodes) - 1], env)
+}
+
+// The names and values of a binding list, as given by bindings(); a malformed
+// one is an error
+func binding_list(node parse.Node, what string) ([]parse.Sym, []parse.Node) {
+    names, vals, ok := bindings(node)
+    if ! ok {
+        list, is_list := node.(*parse.ListNode)
+        switch {
+        case ! is_list:
+            panic("Expected list!")
+        case len(list.List) % 2 != 0:
+            panic(what + " list must have a even number of items!")
+        }
+        panic("Expected symbol!")
+    }
+    return names, vals
+}
+
+func init() {
+    def_special("if", func (args []parse.Node, env *Env) *Object {
+        if len(args) < 2 {
+            panic("if needs a condition and a branch!")
+        }
+        if eval(args[0], env) != GYSP_NIL {
+            return eval(args[1], env)
+        }
+        if len(args) > 2 {
+            return eval(args[2], env)
+        }
+        return GYSP_NIL
+    })
+
+    def_special("for", func (args []parse.Node, env *Env) *Object {
+        if len(args) < 1 {
+            panic("for needs a range list!")
+        }
+        vars, ranges := binding_list(args[0], "Range")
+        lists := EvalList(ranges, env)
+        for _, list := range lists {
+            if list.typ != OBJECT_LIST {
+                panic("Range source must be a list!")
+            }
+        }
+
+        inner_env := new_frame(env, len(vars))
+        gen := NewGenerator()
+        for _, list := range lists {
+            gen.AddSource(list.val.([]*Object))
+        }
+        gen.Generate(func(as []*Object) *Object {
+            // Set loop variables
+            for i, sym := range vars {
+                inner_env.bind(sym, as[i])
+            }
+            // Run body
+            for i := 1; i < len(args); i ++ {
+                eval(args[i], inner_env)        // TODO print results on repl
+            }
+            return GYSP_NIL
+        })
+        return GYSP_NIL
+    })
+
+    def_special("let", func (args []parse.Node, env *Env) *Object {
+        if len(args) < 1 {
+            panic("let needs a binding list!")
+        }
+        names, vals := binding_list(args[0], "Binding")
+
+        // Set bindings
+        inner_env := new_frame(env, len(names))
+        for i, sym := range names {
+            inner_env.bind(sym, eval(vals[i], env))
+        }
+        return eval_body(args[1:], inner_env)
+    })
+
+    def_special("do", func (args []parse.Node, env *Env) *Object {
+        if len(args) < 1 {
+            panic("do needs a body!")
+        }
+        return eval_body(args, NewEnv(env))
+    })
+
+    def_special("set", func (args []parse.Node, env *Env) *Object {
+        if len(args) % 2 != 0 {
+            panic("set needs pairs of references and values!")
+        }
+        val := GYSP_NIL
+        for i := 0; i < len(args); i += 2 {
+            val = eval(args[i + 1], env)
+            assign(args[i], val, env)
+        }
+        return val
+    })
+
+    def_special("fn", func (args []parse.Node, env *Env) *Object {
+        return new_func("", args, env)
+    })
+
+    def_special("defn", func (args []parse.Node, env *Env) *Object {
+        if len(args) < 1 {
+            panic("defn needs a name!")
+        }
+        name := ""
+        switch ref := args[0].(type) {
+        case *parse.SymNode:
+            name = ref.Name
+        case *LocalNode:
+            name = ref.Sym.Name()
+        default:
+            panic("Function name must be a symbol!")
+        }
+        fun := new_func(name, args[1:], env)
+        assign(args[0], fun, env)
+        return fun
+    })
+
+    // (try body... (catch e handler...))
+    def_special("try", func (args []parse.Node, env *Env) (val *Object) {
+        body, clause := try_clauses(args)
+        if clause != nil {
+            if len(clause.Arglist) < 1 {
+                panic("catch needs a variable!")
+            }
+            sym, ok := clause.Arglist[0].(*parse.SymNode)
+            if ! ok {
+                panic("catch needs a variable!")
+            }
+            defer func() {
+                if e := recover(); e != nil {
+                    exc := to_exception(e)
+                    if exc == nil {
+                        panic(e)
+                    }
+                    inner_env := new_frame(env, 1)
+                    inner_env.bind(sym.Sym, exc.Val)
+                    val = eval_body(clause.Arglist[1:], inner_env)
+                }
+            }()
+        }
+        return eval_body(body, env)
+    })
+}
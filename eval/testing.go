@@ -0,0 +1,349 @@
+package eval
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+    "github.com/crides/gysp/parse"
+)
+
+// Tests are defined with (deftest name body...) and run by RunTests(). In a
+// test, (assert= expected actual) and (assert-raises expr [val]) record a
+// failure and go on, and (testing "what" body...) names the assertions in it.
+// Outside of a test a failed assertion is raised as an error. The test and
+// the testing forms are kept along the call chain, so the assertions of the
+// goroutines a test starts count for it, named by the testing forms they
+// were started in; a test should still wait for its goroutines, or their
+// failures come after its result.
+//
+// They're macros, so their names can be used like any other; as macros
+// aren't given their form, where a test or an assertion is is taken from its
+// first argument.
+
+// A test defined by deftest
+type Test struct {
+    Name    string
+    Pos     parse.Pos
+    fun     *Object         // The body, as a function without parameters
+}
+
+// A failed assertion
+type Failure struct {
+    Pos         parse.Pos
+    Context     []string    // The descriptions of the testing forms around it
+    Msg         string
+    Expected    string      // Printed like by the REPL; "" if there's none
+    Actual      string
+    Diff        string      // Where expected and actual differ inside, if they're lists, dicts or strings
+}
+
+func (f * Failure) Error() string {
+    msg := f.Msg
+    if len(f.Context) > 0 {
+        msg = strings.Join(f.Context, " > ") + ": " + msg
+    }
+    if f.Expected != "" {
+        msg += fmt.Sprintf(": expected %s, got %s", f.Expected, f.Actual)
+    }
+    if f.Diff != "" {
+        msg += " (" + f.Diff + ")"
+    }
+    return fmt.Sprintf("%v: %s", f.Pos, msg)
+}
+
+type TestResult struct {
+    Test        *Test
+    Failures    []*Failure
+    Err         error           // Raised by the test and not caught
+    Time        time.Duration
+    lock        sync.Mutex      // For Failures, as goroutines can fail too
+}
+
+func (r * TestResult) Passed() bool {
+    return len(r.Failures) == 0 && r.Err == nil
+}
+
+// The tests defined, in order
+func (it * Interpreter) Tests() []*Test {
+    it.lock.Lock()
+    defer it.lock.Unlock()
+    return append([]*Test(nil), it.tests...)
+}
+
+// Adds a test, or replaces the one with its name
+func (it * Interpreter) add_test(test *Test) {
+    it.lock.Lock()
+    defer it.lock.Unlock()
+    for i, t := range it.tests {
+        if t.Name == test.Name {
+            it.tests[i] = test
+            return
+        }
+    }
+    it.tests = append(it.tests, test)
+}
+
+// Runs each test as an evaluation of its own, so that limits apply to each
+func (it * Interpreter) RunTests(ctx context.Context) []*TestResult {
+    tests := it.Tests()
+    results := make([]*TestResult, len(tests))
+    for i, test := range tests {
+        results[i] = it.run_test(ctx, test)
+    }
+    return results
+}
+
+func (it * Interpreter) run_test(ctx context.Context, test *Test) (res *TestResult) {
+    res = &TestResult{Test: test}
+    start := time.Now()
+    defer func() {
+        res.Time = time.Since(start)
+    }()
+    defer catch(&res.Err)
+    defer it.begin(ctx)()
+    env := *it.Env          // Starts the call chain of the test
+    env.test = res
+    call(test.fun, nil, &env)
+    return res
+}
+
+// Records a failed assertion in the test of the call chain of env, or raises
+// it outside of a test
+func fail(f *Failure, env *Env) {
+    if env == nil || env.test == nil {
+        panic(f.Error() + "!")
+    }
+    res := env.test
+    f.Context = env.testing
+    res.lock.Lock()
+    res.Failures = append(res.Failures, f)
+    res.lock.Unlock()
+}
+
+// Whether a and b are the same value: lists and dicts with the same items,
+// or the same string or number; functions and such are only the same as
+// themselves
+func equal(a, b *Object) bool {
+    if a == b {
+        return true
+    }
+    if a.typ != b.typ {
+        return false
+    }
+    switch a.typ {
+    case OBJECT_NIL, OBJECT_BOOL, OBJECT_INT, OBJECT_FLOAT, OBJECT_CMPLX, OBJECT_STR:
+        return a.val == b.val
+    case OBJECT_LIST:
+        la, lb := a.val.([]*Object), b.val.([]*Object)
+        if len(la) != len(lb) {
+            return false
+        }
+        for i := range la {
+            if ! equal(la[i], lb[i]) {
+                return false
+            }
+        }
+        return true
+    case OBJECT_DICT:
+        da, db := a.val.(map[Object]*Object), b.val.(map[Object]*Object)
+        if len(da) != len(db) {
+            return false
+        }
+        for k, v := range da {
+            if w, ok := db[k]; ! ok || ! equal(v, w) {
+                return false
+            }
+        }
+        return true
+    }
+    return false
+}
+
+// Shortens the rest of a string from where it differs
+func excerpt(s string) string {
+    if len(s) > 20 {
+        s = s[:20] + "..."
+    }
+    return fmt.Sprintf("%q", s)
+}
+
+// Where exp and act first differ, as the path to it and the parts that
+// differ; the path is "" if they differ as a whole
+func first_diff(path string, exp, act *Object) (string, string, string) {
+    if exp.typ == act.typ {
+        switch exp.typ {
+        case OBJECT_STR:
+            es, as := exp.val.(string), act.val.(string)
+            i := 0
+            for i < len(es) && i < len(as) && es[i] == as[i] {
+                i ++
+            }
+            return fmt.Sprintf("%s[%d]", path, i), excerpt(es[i:]), excerpt(as[i:])
+        case OBJECT_LIST:
+            le, la := exp.val.([]*Object), act.val.([]*Object)
+            for i := 0; i < len(le) || i < len(la); i ++ {
+                at := fmt.Sprintf("%s[%d]", path, i)
+                switch {
+                case i >= len(la):
+                    return at, le[i].GoString(), "nothing"
+                case i >= len(le):
+                    return at, "nothing", la[i].GoString()
+                case ! equal(le[i], la[i]):
+                    return first_diff(at, le[i], la[i])
+                }
+            }
+        case OBJECT_DICT:
+            de, da := exp.val.(map[Object]*Object), act.val.(map[Object]*Object)
+            keys := make(map[Object]*Object)
+            for k := range de {
+                keys[k] = nil
+            }
+            for k := range da {
+                keys[k] = nil
+            }
+            for _, k := range sorted_keys(keys) {
+                at := fmt.Sprintf("%s{%s}", path, k.GoString())
+                e, a := de[k], da[k]
+                switch {
+                case a == nil:
+                    return at, e.GoString(), "nothing"
+                case e == nil:
+                    return at, "nothing", a.GoString()
+                case ! equal(e, a):
+                    return first_diff(at, e, a)
+                }
+            }
+        }
+    }
+    return path, exp.GoString(), act.GoString()
+}
+
+// Describes where exp and act differ inside, or "" if they aren't lists,
+// dicts or strings
+func diff(exp, act *Object) string {
+    path, e, a := first_diff("", exp, act)
+    if path == "" {
+        return ""
+    }
+    return fmt.Sprintf("at %s: expected %s, got %s", path, e, a)
+}
+
+// Calls fun from env; returns the exception it raised, or nil
+func raised(fun *Object, env *Env) (exc *Exception) {
+    defer func() {
+        if e := recover(); e != nil {
+            if exc = to_exception(e); exc == nil {
+                panic(e)        // Can't be caught
+            }
+        }
+    }()
+    call(fun, nil, env)
+    return nil
+}
+
+// A function without parameters evaluating body
+func thunk(body []parse.Node) parse.Node {
+    if len(body) == 0 {
+        body = []parse.Node{WrapObject(GYSP_NIL)}
+    }
+    return &parse.CallNode{Fun: parse.NewSymNode("fn"), Arglist: append([]parse.Node{&parse.ListNode{}}, body...)}
+}
+
+// The call an assertion at pos expands to: a primitive given pos, the values
+// of args and the frame it's in
+func assertion(pos parse.Pos, args []parse.Node, check func(pos parse.Pos, vals []*Object, env *Env) *Object) parse.Node {
+    prim := new_caller(func (vals []*Object, env *Env) *Object {
+        return check(pos, vals, env)
+    })
+    return &parse.CallNode{Fun: WrapObject(prim), Arglist: args}
+}
+
+func testing_builtins(it *Interpreter) map[string]*Object {
+    // Calls the body of a testing form with the description added to the
+    // call chain
+    do_testing := new_caller(func (args []*Object, env *Env) *Object {
+        what := args[0]
+        if what.typ != OBJECT_STR {
+            panic("Description of testing must be a string!")
+        }
+        if env == nil {
+            env = it.Env
+        }
+        inner := *env
+        inner.testing = append(env.testing[:len(env.testing):len(env.testing)], what.val.(string))
+        return call(args[1], nil, &inner)
+    })
+    return map[string]*Object {
+        // (deftest name body...) defines a test
+        "deftest": NewMacro(func (args []parse.Node, env *Env) parse.Node {
+            if len(args) < 1 {
+                panic("deftest needs a name!")
+            }
+            name := ""
+            if sym, ok := sym_of(args[0]); ok {
+                name = sym.Name()
+            } else if w, ok := args[0].(*WrapNode); ok && w.Val.typ == OBJECT_STR {
+                name = w.Val.val.(string)
+            }
+            if name == "" {
+                panic("Test name must be a name or a string!")
+            }
+            pos := node_pos(args[0])
+            define := NewPrim(func (args []*Object) *Object {
+                it.add_test(&Test{name, pos, args[0]})
+                return GYSP_NIL
+            })
+            return &parse.CallNode{Fun: WrapObject(define), Arglist: []parse.Node{thunk(args[1:])}}
+        }),
+        // (testing "what" body...) names the assertions in body
+        "testing": NewMacro(func (args []parse.Node, env *Env) parse.Node {
+            if len(args) < 1 {
+                panic("testing needs a description!")
+            }
+            return &parse.CallNode{Fun: WrapObject(do_testing), Arglist: []parse.Node{args[0], thunk(args[1:])}}
+        }),
+        // (assert= expected actual); true if they're equal
+        "assert=": NewMacro(func (args []parse.Node, env *Env) parse.Node {
+            if len(args) != 2 {
+                panic("assert= needs an expected and an actual value!")
+            }
+            return assertion(node_pos(args[0]), args, func (pos parse.Pos, vals []*Object, env *Env) *Object {
+                exp, act := vals[0], vals[1]
+                if equal(exp, act) {
+                    return GYSP_TRUE
+                }
+                fail(&Failure{Pos: pos, Msg: "assert= failed", Expected: exp.GoString(),
+                    Actual: act.GoString(), Diff: diff(exp, act)}, env)
+                return GYSP_NIL
+            })
+        }),
+        // (assert-raises expr [val]) evaluates expr, which should raise an
+        // exception (equal to val, if given); returns its value
+        "assert-raises": NewMacro(func (args []parse.Node, env *Env) parse.Node {
+            if len(args) != 1 && len(args) != 2 {
+                panic("assert-raises needs an expression and maybe the value it raises!")
+            }
+            pos := node_pos(args[0])
+            args = append([]parse.Node{thunk(args[:1])}, args[1:]...)
+            return assertion(pos, args, func (pos parse.Pos, vals []*Object, env *Env) *Object {
+                exc := raised(vals[0], env)
+                f := &Failure{Pos: pos, Msg: "assert-raises failed"}
+                switch {
+                case exc == nil:
+                    f.Msg += ": nothing was raised"
+                case len(vals) == 2:
+                    if equal(vals[1], exc.Val) {
+                        return exc.Val
+                    }
+                    f.Expected, f.Actual, f.Diff = vals[1].GoString(), exc.Val.GoString(), diff(vals[1], exc.Val)
+                default:
+                    return exc.Val
+                }
+                fail(f, env)
+                return GYSP_NIL
+            })
+        }),
+    }
+}
@@ -0,0 +1,142 @@
+package eval
+
+import (
+    "context"
+    "reflect"
+    "testing"
+)
+
+const TESTS_SRC = `(deftest pass
+  (assert= [1 2] [1 2])
+  (assert= {"a" [1]} {"a" [1]}))
+(deftest diffs
+  (assert= [1 [2 3]] [1 [2 4]])
+  (assert= {"a" 1 "b" 2} {"a" 1 "c" 2})
+  (assert= "hello world" "hello there")
+  (assert= [1 2] [1 2 3])
+  (assert= 1 2))
+(deftest raises
+  (assert-raises (throw "x"))
+  (assert-raises (throw "x") "x")
+  (assert-raises (+ 1 2))
+  (assert-raises (throw [1 2]) [1 3]))
+(deftest nested
+  (testing "outer"
+    (assert= 1 2)
+    (testing "inner" (assert= 3 4)))
+  (assert= 5 6))
+(deftest broken (assert= 1 1) (undefined-fn))`
+
+// The tests of TESTS_SRC: whether each passes, its failures and its error
+var TESTS_WANT = []struct {
+    name        string
+    failures    []string
+    err         string
+}{
+    {"pass", nil, ""},
+    {"diffs", []string{
+        "line 5: assert= failed: expected [1 [2 3]], got [1 [2 4]] (at [1][1]: expected 3, got 4)",
+        `line 6: assert= failed: expected {a: 1, b: 2}, got {a: 1, c: 2} (at {"b"}: expected 2, got nothing)`,
+        `line 7: assert= failed: expected "hello world", got "hello there" (at [6]: expected "world", got "there")`,
+        "line 8: assert= failed: expected [1 2], got [1 2 3] (at [2]: expected nothing, got 3)",
+        "line 9: assert= failed: expected 1, got 2",
+    }, ""},
+    {"raises", []string{
+        "line 13: assert-raises failed: nothing was raised",
+        "line 14: assert-raises failed: expected [1 3], got [1 2] (at [1]: expected 3, got 2)",
+    }, ""},
+    {"nested", []string{
+        "line 17: outer: assert= failed: expected 1, got 2",
+        "line 18: outer > inner: assert= failed: expected 3, got 4",
+        "line 19: assert= failed: expected 5, got 6",
+    }, ""},
+    {"broken", nil, "Variable undefined-fn not defined!"},
+}
+
+func TestRunTests(t *testing.T) {
+    it := NewInterpreter()
+    if _, err := it.Eval(TESTS_SRC); err != nil {
+        t.Fatal(err)
+    }
+    results := it.RunTests(context.Background())
+    if len(results) != len(TESTS_WANT) {
+        t.Fatalf("Ran %d tests, want %d", len(results), len(TESTS_WANT))
+    }
+    for i, want := range TESTS_WANT {
+        res := results[i]
+        if res.Test.Name != want.name {
+            t.Errorf("Test %d is %s, want %s", i, res.Test.Name, want.name)
+        }
+        if res.Passed() != (want.failures == nil && want.err == "") {
+            t.Errorf("%s passed: %v", want.name, res.Passed())
+        }
+        if len(res.Failures) != len(want.failures) {
+            t.Errorf("%s: %d failures, want %d", want.name, len(res.Failures), len(want.failures))
+            continue
+        }
+        for j, f := range res.Failures {
+            if f.Error() != want.failures[j] {
+                t.Errorf("%s: failure %q, want %q", want.name, f.Error(), want.failures[j])
+            }
+        }
+        err := ""
+        if res.Err != nil {
+            err = res.Err.Error()
+        }
+        if err != want.err {
+            t.Errorf("%s: error %q, want %q", want.name, err, want.err)
+        }
+    }
+    if failures := results[2].Failures; failures[1].Expected != "[1 3]" || failures[1].Diff != "at [1]: expected 3, got 2" {
+        t.Errorf("Fields of the failure: %+v", failures[1])
+    }
+}
+
+func TestAssertOutsideTests(t *testing.T) {
+    check_evals(t, NewInterpreter(), []eval_test{
+        {`(assert= [1 2] [1 2])`, "true"},
+        {`(assert= [1 2] [1 3])`, "error: line 1: assert= failed: expected [1 2], got [1 3] (at [1]: expected 2, got 3)!"},
+        {`(assert-raises (throw "x"))`, `"x"`},
+        {`(assert-raises 1)`, "error: line 1: assert-raises failed: nothing was raised!"},
+        {`(testing "what" (assert= 1 1))`, "true"},
+        // They're macros, not special forms
+        {`(let [testing 1 deftest 2 assert= 3 assert-raises 4] (+ testing deftest assert= assert-raises))`, "10"},
+    })
+}
+
+// Assertions from goroutines count for the test running
+func TestAssertParallel(t *testing.T) {
+    it := NewInterpreter()
+    it.Parallelism = 4
+    it.Eval(`(deftest parallel (testing "items" (pmap (fn [x] (assert= 0 x)) (range 20))))`)
+    res := it.RunTests(context.Background())[0]
+    if len(res.Failures) != 19 {
+        t.Errorf("%d failures, want 19", len(res.Failures))
+    }
+    for _, f := range res.Failures {
+        if len(f.Context) != 1 || f.Context[0] != "items" {
+            t.Errorf("Failure in %v", f.Context)
+        }
+    }
+}
+
+// A goroutine is named by the testing forms it was started in, on the VM too
+func TestTestingGoroutines(t *testing.T) {
+    for _, vm := range []bool{false, true} {
+        it := NewInterpreter()
+        it.VM = vm
+        it.Eval(`(deftest chains
+  (let [ch (chan)]
+    (testing "a" (go (fn [] (assert= 1 2) (send ch 1))))
+    (testing "b" (recv ch) (assert= 3 4))))`)
+        res := it.RunTests(context.Background())[0]
+        got := []string{}
+        for _, f := range res.Failures {
+            got = append(got, f.Error())
+        }
+        want := []string{"line 3: a: assert= failed: expected 1, got 2", "line 4: b: assert= failed: expected 3, got 4"}
+        if ! reflect.DeepEqual(got, want) {
+            t.Errorf("vm %v: failures %q, want %q", vm, got, want)
+        }
+    }
+}
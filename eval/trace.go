@@ -0,0 +1,162 @@
+package eval
+
+import (
+    "fmt"
+    "strings"
+    "github.com/crides/gysp/parse"
+)
+
+// Hooks observe an evaluation: they're called around the calls of functions
+// and primitives in the code, and after a macro expands. depth is the number
+// of frames of the environment of the form, 0 at the top level. Like the
+// debugger they follow the tree walker, so with hooks set programs aren't run
+// on the VM. They're called on the goroutine making the call, so hooks for
+// code with goroutines must be safe for that. BaseHooks can be embedded to
+// implement only some of them.
+type Hooks interface {
+    OnCall(n *parse.CallNode, depth int, fun *Object, args []*Object)
+    OnReturn(n *parse.CallNode, depth int, fun *Object, val *Object)
+    OnError(n *parse.CallNode, depth int, fun *Object, err error)     // The error is raised again after it
+    OnMacroExpand(n *parse.CallNode, depth int, expansion parse.Node)
+}
+
+// Hooks that do nothing
+type BaseHooks struct {}
+
+func (BaseHooks) OnCall(*parse.CallNode, int, *Object, []*Object) {}
+func (BaseHooks) OnReturn(*parse.CallNode, int, *Object, *Object) {}
+func (BaseHooks) OnError(*parse.CallNode, int, *Object, error) {}
+func (BaseHooks) OnMacroExpand(*parse.CallNode, int, parse.Node) {}
+
+// Calls fun with the hooks around it
+func (it * Interpreter) hooked_call(n *parse.CallNode, env *Env, fun *Object, args []*Object) *Object {
+    hooks, depth := it.Hooks, env.Depth()
+    hooks.OnCall(n, depth, fun, args)
+    defer func() {
+        if e := recover(); e != nil {
+            hooks.OnError(n, depth, fun, to_error(e))
+            panic(e)
+        }
+    }()
+    val := call(fun, args, env)
+    hooks.OnReturn(n, depth, fun, val)
+    return val
+}
+
+// A global function replaced by trace
+type traced struct {
+    orig    *Object
+    wrapper *Object
+}
+
+// Prints a line of a trace to Stderr, indented by the nesting of the traced
+// calls
+func (it * Interpreter) trace_line(depth int, line string) {
+    it.io_lock.Lock()
+    defer it.io_lock.Unlock()
+    fmt.Fprintf(it.Stderr, "%s%s\n", strings.Repeat("| ", depth), line)
+}
+
+// A function that calls fun, logging its calls, results and errors. The
+// nesting is counted along the call chain, so goroutines have their own.
+func (it * Interpreter) tracer(name string, fun *Object) *Object {
+    return new_caller(func (args []*Object, caller *Env) *Object {
+        strs := make([]string, len(args) + 1)
+        strs[0] = name
+        for i, arg := range args {
+            strs[i + 1] = arg.GoString()
+        }
+        if caller == nil {      // Called from Go
+            caller = it.Env
+        }
+        inner := *caller        // The same frame, one traced call deeper
+        inner.traced ++
+        depth := caller.traced
+        it.trace_line(depth, "(" + strings.Join(strs, " ") + ")")
+        defer func() {
+            if e := recover(); e != nil {
+                it.trace_line(depth, "!! " + to_error(e).Error())
+                panic(e)
+            }
+        }()
+        val := call(fun, args, &inner)
+        it.trace_line(depth, "=> " + val.GoString())
+        return val
+    })
+}
+
+// The names of the global functions given to trace or untrace, as strings or
+// as the functions themselves
+func (it * Interpreter) trace_syms(name string, args []*Object) []parse.Sym {
+    syms := make([]parse.Sym, 0, len(args))
+    for _, arg := range args {
+        switch arg.typ {
+        case OBJECT_STR:
+            syms = append(syms, parse.Intern(arg.val.(string)))
+        case OBJECT_FUNC, OBJECT_PRIM:
+            found := false
+            for _, sym := range it.Env.global.syms() {
+                if val, _ := it.Env.global.lookup(sym); val == arg {
+                    syms, found = append(syms, sym), true
+                }
+            }
+            if ! found {
+                panic(fmt.Sprintf("%s: the function is not a global!", name))
+            }
+        default:
+            panic(fmt.Sprintf("%s needs global functions or their names!", name))
+        }
+    }
+    return syms
+}
+
+func trace_builtins(it *Interpreter) map[string]*Object {
+    return map[string]*Object {
+        // (trace f g ...) logs the calls of the global functions f, g, ... to
+        // Stderr; they can also be given by name, like (trace "f")
+        "trace": NewPrim(func (args []*Object) *Object {
+            it.check_stdio()
+            for _, sym := range it.trace_syms("trace", args) {
+                it.check_frozen(sym)
+                fun := it.Env.get(sym)
+                if fun.typ != OBJECT_FUNC && fun.typ != OBJECT_PRIM {
+                    panic(fmt.Sprintf("Cannot trace a %s!", fun.typ.String()))
+                }
+                it.lock.Lock()
+                if t, ok := it.traced[sym]; ! ok || t.wrapper != fun {
+                    if it.traced == nil {
+                        it.traced = make(map[parse.Sym]traced)
+                    }
+                    t = traced{fun, it.tracer(sym.Name(), fun)}
+                    it.traced[sym] = t
+                    it.Env.global.store(sym, t.wrapper)
+                }
+                it.lock.Unlock()
+            }
+            return GYSP_NIL
+        }),
+        // (untrace f g ...) stops tracing them; (untrace) stops tracing all
+        "untrace": NewPrim(func (args []*Object) *Object {
+            syms := it.trace_syms("untrace", args)
+            it.lock.Lock()
+            defer it.lock.Unlock()
+            if len(args) == 0 {
+                for sym := range it.traced {
+                    syms = append(syms, sym)
+                }
+            }
+            for _, sym := range syms {
+                t, ok := it.traced[sym]
+                if ! ok {
+                    continue
+                }
+                delete(it.traced, sym)
+                if val, _ := it.Env.global.lookup(sym); val == t.wrapper {    // Unless it was redefined
+                    it.check_frozen(sym)
+                    it.Env.global.store(sym, t.orig)
+                }
+            }
+            return GYSP_NIL
+        }),
+    }
+}
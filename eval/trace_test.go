@@ -0,0 +1,120 @@
+package eval
+
+import (
+    "bytes"
+    "fmt"
+    "reflect"
+    "strings"
+    "testing"
+    "github.com/crides/gysp/parse"
+)
+
+// Hooks that keep what they're called with; nodes are logged as the name of
+// the function and the line
+type recording_hooks struct {
+    log []string
+}
+
+func node_name(node parse.Node) string {
+    n := node.(*parse.CallNode)
+    sym, _ := sym_of(n.Fun)
+    return fmt.Sprintf("%s:%d", sym.Name(), n.Pos.Line)
+}
+
+func (h * recording_hooks) OnCall(n *parse.CallNode, depth int, fun *Object, args []*Object) {
+    h.log = append(h.log, fmt.Sprintf("call %s %d %v", node_name(n), depth, args))
+}
+
+func (h * recording_hooks) OnReturn(n *parse.CallNode, depth int, fun *Object, val *Object) {
+    h.log = append(h.log, fmt.Sprintf("return %s %d %v", node_name(n), depth, val))
+}
+
+func (h * recording_hooks) OnError(n *parse.CallNode, depth int, fun *Object, err error) {
+    h.log = append(h.log, fmt.Sprintf("error %s %d %v", node_name(n), depth, err))
+}
+
+func (h * recording_hooks) OnMacroExpand(n *parse.CallNode, depth int, expansion parse.Node) {
+    h.log = append(h.log, fmt.Sprintf("expand %s %d %s", node_name(n), depth, node_name(expansion)))
+}
+
+func TestHooks(t *testing.T) {
+    it := NewInterpreter()
+    it.VM = true        // Ignored with hooks
+    it.Define("wrap", NewMacro(wrap_macro))
+    it.Eval("(defn sq [x]\n  (* x x))")
+    hooks := &recording_hooks{}
+    it.Hooks = hooks
+    for _, test := range []struct {
+        code    string
+        log     []string
+    }{
+        {`(sq 3)`, []string{"call sq:1 0 [3]", "call *:2 1 [3 3]", "return *:2 1 9", "return sq:1 0 9"}},
+        {"(let [a 0]\n  (/ 1 a))", []string{"call /:2 1 [1 0]", "error /:2 1 Division by zero!"}},
+        {`(wrap (sq 2))`, []string{"expand wrap:1 0 let:0", "call sq:1 1 [2]", "call *:2 1 [2 2]", "return *:2 1 4", "return sq:1 1 4"}},
+    } {
+        hooks.log = nil
+        it.Eval(test.code)
+        if ! reflect.DeepEqual(hooks.log, test.log) {
+            t.Errorf("%s called %q, want %q", test.code, hooks.log, test.log)
+        }
+    }
+}
+
+func TestTrace(t *testing.T) {
+    it := NewInterpreter()
+    var stderr bytes.Buffer
+    it.Stderr = &stderr
+    it.Eval(`(defn sq [x] (* x x)) (defn sumsq [a b] (+ (sq a) (sq b)))`)
+    for _, test := range []struct {
+        code    string
+        trace   string
+    }{
+        {`(do (trace sq "sumsq") (sumsq 3 4))`, "(sumsq 3 4)\n| (sq 3)\n| => 9\n| (sq 4)\n| => 16\n=> 25\n"},
+        {`(sq "a")`, "(sq \"a\")\n!! No multiplication for type 'str'!\n"},
+        {`(do (untrace sumsq) (sumsq 1 2))`, "(sq 1)\n=> 1\n(sq 2)\n=> 4\n"},
+        {`(do (untrace) (sumsq 1 2))`, ""},
+    } {
+        stderr.Reset()
+        it.Eval(test.code)
+        if got := stderr.String(); got != test.trace {
+            t.Errorf("%s traced %q, want %q", test.code, got, test.trace)
+        }
+    }
+    check_evals(t, it, []eval_test{
+        {`(trace (fn [] 1))`, "error: trace: the function is not a global!"},
+        {`(trace 1)`, "error: trace needs global functions or their names!"},
+    })
+}
+
+// Each goroutine has its own nesting
+func TestTraceGoroutines(t *testing.T) {
+    it := NewInterpreter()
+    it.Parallelism = 4
+    var stderr bytes.Buffer
+    it.Stderr = &stderr
+    check_evals(t, it, []eval_test{
+        {`(defn leaf [x] x) (defn node [x] (leaf x)) (trace node leaf)`, "nil"},
+        {`(pmap node (range 20))`, "[0 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16 17 18 19]"},
+    })
+    nested := 0
+    for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+        if strings.HasPrefix(line, "| | ") {
+            t.Errorf("Nested too deep: %q", line)
+        } else if strings.HasPrefix(line, "| ") {
+            nested ++
+        }
+    }
+    if nested != 40 {       // Each call and result of leaf
+        t.Errorf("%d nested lines, want 40", nested)
+    }
+}
+
+// Forms that don't need their arguments unevaluated are functions, and
+// select is a macro, so their names can be used like any other
+func TestNotReserved(t *testing.T) {
+    check_evals(t, NewInterpreter(), []eval_test{
+        {`(let [trace 1 untrace 2 breakpoint 3 select 4] (+ trace untrace breakpoint select))`, "10"},
+        {`(defn f [select] (* select 2)) (f 4)`, "8"},
+        {`(let [recv 1] (select (default recv)))`, "1"},
+    })
+}
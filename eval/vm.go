@@ -0,0 +1,173 @@
+package eval
+
+import (
+    "fmt"
+    "github.com/crides/gysp/parse"
+)
+
+// The operand stack of the VM; the top is at the end
+type Stack []*Object
+
+func (s * Stack) Push(o *Object) {
+    *s = append(*s, o)
+}
+
+func (s * Stack) Pop() (o *Object) {
+    o, *s = (*s)[len(*s) - 1], (*s)[:len(*s) - 1]
+    return
+}
+
+func (s * Stack) Top() *Object {
+    return (*s)[len(*s) - 1]
+}
+
+// Pops the top n items, in the order they were pushed
+func (s * Stack) Popn(n int) []*Object {
+    items := make([]*Object, n)
+    copy(items, (*s)[len(*s) - n:])
+    *s = (*s)[:len(*s) - n]
+    return items
+}
+
+func (s * Stack) Size() int {
+    return len(*s)
+}
+
+// State of a running `for'; it walks through all the combinations of the
+// items of the lists, like the Generator does
+type loop struct {
+    vars    *layout
+    lists   [][]*Object
+    ptrs    []int
+    done    bool
+}
+
+func new_loop(vars *layout, lists []*Object) *loop {
+    l := &loop{vars: vars, ptrs: make([]int, len(lists))}
+    for _, list := range lists {
+        if list.typ != OBJECT_LIST {
+            panic("Range source must be a list!")
+        }
+        items := list.val.([]*Object)
+        if len(items) == 0 {
+            l.done = true
+        }
+        l.lists = append(l.lists, items)
+    }
+    return l
+}
+
+// Binds the next combination in env; returns false if there are no more
+func (l * loop) next(env *Env) bool {
+    if l.done {
+        return false
+    }
+    for i, items := range l.lists {
+        env.slots[l.vars.slots[i]] = items[l.ptrs[i]]
+    }
+
+    // Increment pointers
+    i := len(l.ptrs) - 1
+    for ; i >= 0; i -- {
+        if l.ptrs[i] ++; l.ptrs[i] < len(l.lists[i]) {
+            break
+        }
+        l.ptrs[i] = 0
+    }
+    l.done = i < 0      // The first pointer went through
+    return true
+}
+
+// Runs compiled code in env
+func Run(code *Code, env *Env) *Object {
+    stack := make(Stack, 0, code.size)
+    var loops []*loop
+    instrs := code.instrs
+    it := env.it
+    // Steps are only counted for calls and loop iterations; code without
+    // either runs through once, so it can't go on for long
+    for pc := 0; pc < len(instrs); pc ++ {
+        ins := instrs[pc]
+        switch ins.op {
+        case OP_CONST:
+            stack.Push(code.consts[ins.arg])
+        case OP_NIL:
+            stack.Push(GYSP_NIL)
+        case OP_LOAD:
+            stack.Push(env.get(code.names[ins.arg]))
+        case OP_LOCAL:
+            l := code.locals[ins.arg]
+            stack.Push(env.GetLocal(l.Depth, l.Slot))
+        case OP_POP:
+            stack.Pop()
+        case OP_LIST:
+            stack.Push(NewObject(OBJECT_LIST, stack.Popn(int(ins.arg))))
+        case OP_DICT:
+            items := stack.Popn(2 * int(ins.arg))
+            dict := make(map[Object]*Object)
+            for i := 0; i < len(items); i += 2 {
+                dict[*items[i]] = items[i + 1]
+            }
+            stack.Push(NewObject(OBJECT_DICT, dict))
+        case OP_MACRO:
+            if stack.Top().typ == OBJECT_MACRO {
+                site := code.calls[ins.arg]
+                macro := stack.Pop().val.(func([]parse.Node, *Env) parse.Node)
+                stack.Push(eval(expand(macro, site.node.Arglist, env), env))
+                pc = site.end - 1
+            }
+        case OP_CALL:
+            // The arguments are passed as they are on the stack, without copying
+            if it != nil {
+                it.step()
+            }
+            base := len(stack) - int(ins.arg)
+            ret_val := call(stack[base - 1], stack[base:], env)
+            stack = stack[:base - 1]
+            stack.Push(ret_val)
+        case OP_JUMP:
+            if it != nil && int(ins.arg) <= pc {    // Back to the start of a loop
+                it.step()
+            }
+            pc = int(ins.arg) - 1
+        case OP_JUMP_NIL:
+            if stack.Pop() == GYSP_NIL {
+                pc = int(ins.arg) - 1
+            }
+        case OP_FRAME:
+            frame := code.frames[ins.arg]
+            base := len(stack) - len(frame.slots)
+            env = frame.frame(env, stack[base:])
+            stack = stack[:base]
+        case OP_LEAVE:
+            env = env.next
+        case OP_FOR:
+            vars := code.loops[ins.arg]
+            base := len(stack) - len(vars.slots)
+            loops = append(loops, new_loop(vars, stack[base:]))
+            stack = stack[:base]
+            env = vars.frame(env, nil)
+        case OP_NEXT:
+            if ! loops[len(loops) - 1].next(env) {
+                loops = loops[:len(loops) - 1]
+                pc = int(ins.arg) - 1
+            }
+        case OP_EVAL:
+            stack.Push(eval(code.nodes[ins.arg], env))
+        case OP_FUNC:
+            fun := *code.funcs[ins.arg]
+            fun.env = env
+            stack.Push(NewObject(OBJECT_FUNC, &fun))
+        case OP_SET:
+            assign(code.refs[ins.arg], stack.Top(), env)
+        default:
+            panic(fmt.Sprintf("Internal: unknown opcode %d!", ins.op))
+        }
+    }
+    return stack.Pop()
+}
+
+// Compiles and runs a program; a drop-in for Eval()
+func Exec(node parse.Node, env *Env) *Object {
+    return Run(Compile(node), env)
+}
@@ -0,0 +1,110 @@
+package eval
+
+import (
+    "testing"
+    "github.com/crides/gysp/parse"
+)
+
+// Programs for comparing the tree walker with the VM
+var BENCH_PROGS = []struct {
+    name    string
+    code    string
+}{
+    {"arith", `(for [i (range 1000)] (+ (* i 2) (- i 1) (/ i 3) (% i 7)))`},
+    {"nested-for", `(for [i (range 40) j (range 40)] (if (% (+ i j) 2) (* i j) (+ i j)))`},
+    {"let", `(for [i (range 500)] (let [a i b (* i 2)] (let [c (+ a b)] (* c c))))`},
+    {"do", `(for [i (range 500)] (do (+ i 1) (do (+ i 2) (+ i 3))))`},
+    {"lists", `(for [i (range 300)] [i (+ i 1) [(* i 2) "s"]])`},
+    {"calls", `(let [sq (fn [x] (* x x)) add (fn [a b] (+ a b))] (for [i (range 500)] (add (sq i) (sq (+ i 1)))))`},
+    {"closures", `(defn adder [n] (fn [x] (+ x n))) (for [i (range 300)] ((adder i) (* i 2)))`},
+}
+
+// Programs whose values have to be the same on the tree walker and the VM
+var SAME_PROGS = []string{
+    `(+ 1 (* 2 (- 10 4)) (/ 9 3))`,
+    `[(+ 1 2) [(* 3 4) (- 5 (+ 1 1))] "s"]`,
+    `(let [a 1] (let [a 2 b a] [a b]))`,
+    `(let [a (+ 1 2)] (if a [a (+ a 1)] nil))`,
+    `(if nil (let [a] a) (do 1 2))`,      // Malformed, but never run
+    `(if nil (for [i] i))`,
+    `(for [i [] j [1 2]] i)`,
+    `(defn sq [x] (* x x)) (sq 12)`,
+    `((fn [a b] [b a]) 1 2)`,
+    `(defn adder [n] (fn [x] (+ x n))) ((adder 10) 5)`,
+    `(let [n 1] (defn bump [] (set n (+ n 1))) (bump) (bump) n)`,
+    `(let [a nil b nil] (for [i [1 2]] (if a (set b (fn [] i)) (set a (fn [] i)))) [(a) (b)])`,
+    `(defn f [x] (let [y (* x 2)] (do (defn g [z] (+ y z)) (g x)))) (f 4)`,
+    `(set x 1 y (+ x 1)) [x y]`,
+    `(defn compose [f g] (fn [x] (f (g x)))) ((compose (fn [x] (+ x 1)) (fn [x] (* x 3))) 7)`,
+    `(defn k [] 1 2 3) (k)`,
+    `(if nil (fn) 1)`,
+    `(let [a 1 b 2 a 3] [a b])`,
+    `((fn [x y x] [x y]) 1 2 3)`,
+    `(for [i [1 2] i [3 4]] i)`,
+}
+
+func TestVMMatchesEval(t *testing.T) {
+    progs := append([]string(nil), SAME_PROGS...)
+    for _, prog := range BENCH_PROGS {
+        progs = append(progs, prog.code)
+    }
+    for _, prog := range progs {
+        want := Eval(parse_prog(prog), StandardEnv())
+        got := Run(Compile(parse_prog(prog)), StandardEnv())
+        if got.GoString() != want.GoString() {
+            t.Errorf("%s: VM gave %s, eval gave %s", prog, got.GoString(), want.GoString())
+        }
+    }
+}
+
+func TestVMCompilesFunctions(t *testing.T) {
+    code := Compile(parse_prog(`(defn f [x] (+ x 1)) (f 2)`))
+    if len(code.nodes) != 0 {
+        t.Errorf("Left %v to eval()", code.nodes)
+    }
+    if len(code.funcs) != 1 || code.funcs[0].code == nil {
+        t.Fatalf("Function body not compiled:\n%v", code)
+    }
+}
+
+func TestVMMalformedSpecial(t *testing.T) {
+    for _, prog := range []string{`(let [a] a)`, `(let a 1)`, `(for [i] i)`, `(if 1)`, `(do)`, `(fn x 1)`, `(defn 1 [] 1)`, `(set a)`} {
+        code := Compile(parse_prog(prog))       // Mustn't panic yet
+        func() {
+            defer func() {
+                if recover() == nil {
+                    t.Errorf("%s ran without an error", prog)
+                }
+            }()
+            Run(code, StandardEnv())
+        }()
+    }
+}
+
+func parse_prog(code string) parse.Node {
+    return parse.Parse(parse.NewLexer().Lex(code))
+}
+
+func BenchmarkEval(b *testing.B) {
+    for _, prog := range BENCH_PROGS {
+        node := parse_prog(prog.code)
+        b.Run(prog.name, func(b *testing.B) {
+            env := StandardEnv()
+            for i := 0; i < b.N; i ++ {
+                Eval(node, env)
+            }
+        })
+    }
+}
+
+func BenchmarkVM(b *testing.B) {
+    for _, prog := range BENCH_PROGS {
+        code := Compile(parse_prog(prog.code))
+        b.Run(prog.name, func(b *testing.B) {
+            env := StandardEnv()
+            for i := 0; i < b.N; i ++ {
+                Run(code, env)
+            }
+        })
+    }
+}
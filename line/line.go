@@ -0,0 +1,499 @@
+package line
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+)
+
+// A small line editor for the REPL: cursor movement, history (optionally
+// persisted to a file), reverse search, bracket matching and tab completion.
+// When the input is not a terminal it just reads plain lines.
+
+var ErrInterrupt = errors.New("Interrupted")    // Returned on Ctrl-C
+
+const HISTORY_SIZE = 1000
+
+// Key codes; control keys keep their ASCII value
+const (
+    KEY_CTRL_A      = 1
+    KEY_CTRL_B      = 2
+    KEY_CTRL_C      = 3
+    KEY_CTRL_D      = 4
+    KEY_CTRL_E      = 5
+    KEY_CTRL_F      = 6
+    KEY_CTRL_G      = 7
+    KEY_BACKSPACE   = 8
+    KEY_TAB         = 9
+    KEY_CTRL_K      = 11
+    KEY_CTRL_L      = 12
+    KEY_ENTER       = 13
+    KEY_CTRL_N      = 14
+    KEY_CTRL_P      = 16
+    KEY_CTRL_R      = 18
+    KEY_CTRL_U      = 21
+    KEY_CTRL_W      = 23
+    KEY_ESC         = 27
+    KEY_DEL         = 127
+)
+
+// Keys sent as escape sequences get negative codes
+const (
+    KEY_UP          = -1 - iota
+    KEY_DOWN
+    KEY_RIGHT
+    KEY_LEFT
+    KEY_HOME
+    KEY_END
+    KEY_DELETE
+    KEY_UNKNOWN
+    KEY_NONE                    // No key; for a key to be handled later
+)
+
+type Editor struct {
+    Complete    func(word string) []string  // Candidates for the word before the cursor
+
+    in          *bufio.Reader
+    out         io.Writer
+    fd          int
+    term        bool            // Whether the input is a terminal
+
+    prompt      string
+    line        []rune
+    pos         int             // Cursor position in line
+
+    history     []string
+    hist_ind    int             // Position while browsing the history
+    hist_file   string
+    saved       []rune          // Line being edited before browsing the history
+    tabbed      bool            // Whether the last key was a tab
+}
+
+// Creates an editor on stdin/stdout. hist_file can be empty to keep the
+// history in memory only.
+func NewEditor(hist_file string) *Editor {
+    e := &Editor{
+        in: bufio.NewReader(os.Stdin),
+        out: os.Stdout,
+        fd: int(os.Stdin.Fd()),
+        hist_file: hist_file,
+    }
+    e.term = is_terminal(e.fd) && is_terminal(int(os.Stdout.Fd()))
+    if e.term && hist_file != "" {
+        e.load_history()
+    }
+    return e
+}
+
+func (e * Editor) load_history() {
+    f, err := os.Open(e.hist_file)
+    if err != nil {
+        return
+    }
+    defer f.Close()
+
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        if line := scanner.Text(); line != "" {
+            e.history = append(e.history, line)
+        }
+    }
+    if len(e.history) > HISTORY_SIZE {
+        e.history = e.history[len(e.history) - HISTORY_SIZE:]
+        e.save_history()
+    }
+}
+
+// Rewrites the history file with the history in memory
+func (e * Editor) save_history() {
+    if f, err := os.Create(e.hist_file); err == nil {
+        for _, line := range e.history {
+            fmt.Fprintln(f, line)
+        }
+        f.Close()
+    }
+}
+
+func (e * Editor) AddHistory(line string) {
+    if strings.TrimSpace(line) == "" {
+        return
+    }
+    if n := len(e.history); n > 0 && e.history[n - 1] == line {
+        return
+    }
+    e.history = append(e.history, line)
+    if len(e.history) > HISTORY_SIZE {
+        e.history = e.history[1:]
+    }
+    if e.term && e.hist_file != "" {
+        if f, err := os.OpenFile(e.hist_file, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0600); err == nil {
+            fmt.Fprintln(f, line)
+            f.Close()
+        }
+    }
+}
+
+func (e * Editor) History() []string {
+    return e.history
+}
+
+// Reads a line; returns io.EOF on Ctrl-D or the end of input and ErrInterrupt
+// on Ctrl-C. Lines read are added to the history.
+func (e * Editor) ReadLine(prompt string) (string, error) {
+    if ! e.term {
+        return e.read_plain(prompt)
+    }
+    restore, err := make_raw(e.fd)
+    if err != nil {
+        return e.read_plain(prompt)
+    }
+    defer restore()
+    return e.edit(prompt)
+}
+
+// Edits a line with the terminal in raw mode
+func (e * Editor) edit(prompt string) (string, error) {
+    e.prompt, e.line, e.pos = prompt, nil, 0
+    e.hist_ind, e.saved, e.tabbed = len(e.history), nil, false
+    e.refresh()
+    pending := KEY_NONE         // The key that ended a search
+    for {
+        key := pending
+        if pending == KEY_NONE {
+            var err error
+            if key, err = e.read_key(); err != nil {
+                return "", err
+            }
+        }
+        pending = KEY_NONE
+
+        tabbed := false
+        switch key {
+        case KEY_ENTER, '\n':
+            e.pos = len(e.line)
+            e.refresh_plain()       // Remove the bracket highlight
+            e.write("\n")
+            line := string(e.line)
+            e.AddHistory(line)
+            return line, nil
+        case KEY_CTRL_C:
+            e.write("^C\n")
+            return "", ErrInterrupt
+        case KEY_CTRL_D:
+            if len(e.line) == 0 {
+                e.write("\n")
+                return "", io.EOF
+            }
+            e.delete(e.pos)
+        case KEY_BACKSPACE, KEY_DEL:
+            if e.pos > 0 {
+                e.pos --
+                e.delete(e.pos)
+            }
+        case KEY_DELETE:
+            e.delete(e.pos)
+        case KEY_LEFT, KEY_CTRL_B:
+            if e.pos > 0 {
+                e.pos --
+            }
+        case KEY_RIGHT, KEY_CTRL_F:
+            if e.pos < len(e.line) {
+                e.pos ++
+            }
+        case KEY_HOME, KEY_CTRL_A:
+            e.pos = 0
+        case KEY_END, KEY_CTRL_E:
+            e.pos = len(e.line)
+        case KEY_UP, KEY_CTRL_P:
+            e.browse(-1)
+        case KEY_DOWN, KEY_CTRL_N:
+            e.browse(1)
+        case KEY_CTRL_K:
+            e.line = e.line[:e.pos]
+        case KEY_CTRL_U:
+            e.line = e.line[e.pos:]
+            e.pos = 0
+        case KEY_CTRL_W:
+            start := e.pos
+            for start > 0 && e.line[start - 1] == ' ' {
+                start --
+            }
+            for start > 0 && e.line[start - 1] != ' ' {
+                start --
+            }
+            e.line = append(e.line[:start], e.line[e.pos:]...)
+            e.pos = start
+        case KEY_CTRL_L:
+            e.write("\x1b[H\x1b[2J")
+        case KEY_CTRL_R:
+            line, ok, next, err := e.search()
+            if err != nil {
+                return "", err
+            } else if ok {
+                e.write("\n")
+                e.AddHistory(line)
+                return line, nil
+            }
+            pending = next
+        case KEY_TAB:
+            e.complete()
+            tabbed = true
+        default:
+            if key >= ' ' {
+                e.insert(rune(key))
+            }
+        }
+        e.tabbed = tabbed
+        e.refresh()
+    }
+}
+
+func (e * Editor) read_plain(prompt string) (string, error) {
+    e.write(prompt)
+    line, err := e.in.ReadString('\n')
+    if err != nil && (err != io.EOF || line == "") {
+        return "", err
+    }
+    return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (e * Editor) write(s string) {
+    io.WriteString(e.out, s)
+}
+
+// Reads a key press, decoding the escape sequences of the special keys
+func (e * Editor) read_key() (int, error) {
+    r, _, err := e.in.ReadRune()
+    if err != nil {
+        return 0, err
+    }
+    if r != KEY_ESC {
+        return int(r), nil
+    }
+
+    if e.in.Buffered() == 0 {   // A lone escape
+        return KEY_ESC, nil
+    }
+    r, _, err = e.in.ReadRune()
+    if err != nil {
+        return 0, err
+    }
+    if r != '[' && r != 'O' {
+        return KEY_UNKNOWN, nil     // Alt + key
+    }
+    seq := ""
+    for {
+        r, _, err = e.in.ReadRune()
+        if err != nil {
+            return 0, err
+        }
+        seq += string(r)
+        if r >= 0x40 && r <= 0x7e {     // Final byte of the sequence
+            break
+        }
+    }
+    switch seq {
+    case "A":
+        return KEY_UP, nil
+    case "B":
+        return KEY_DOWN, nil
+    case "C":
+        return KEY_RIGHT, nil
+    case "D":
+        return KEY_LEFT, nil
+    case "H", "1~", "7~":
+        return KEY_HOME, nil
+    case "F", "4~", "8~":
+        return KEY_END, nil
+    case "3~":
+        return KEY_DELETE, nil
+    }
+    return KEY_UNKNOWN, nil
+}
+
+func (e * Editor) insert(rs ...rune) {
+    line := make([]rune, 0, len(e.line) + len(rs))
+    line = append(line, e.line[:e.pos]...)
+    line = append(line, rs...)
+    e.line = append(line, e.line[e.pos:]...)
+    e.pos += len(rs)
+}
+
+func (e * Editor) delete(pos int) {
+    if pos < len(e.line) {
+        e.line = append(e.line[:pos], e.line[pos + 1:]...)
+    }
+}
+
+// Moves through the history by dir entries, keeping the line being edited
+func (e * Editor) browse(dir int) {
+    ind := e.hist_ind + dir
+    if ind < 0 || ind > len(e.history) {
+        return
+    }
+    if e.hist_ind == len(e.history) {
+        e.saved = e.line
+    }
+    e.hist_ind = ind
+    if ind == len(e.history) {
+        e.line = e.saved
+    } else {
+        e.line = []rune(e.history[ind])
+    }
+    e.pos = len(e.line)
+}
+
+// Runs an incremental reverse search through the history. Returns the line
+// and true if it was accepted with Enter; other keys leave the match in the
+// buffer to be edited, and are returned to be handled like any key.
+func (e * Editor) search() (string, bool, int, error) {
+    query := []rune{}
+    ind := len(e.history)       // Index of the current match
+    match := ""
+    find := func(from int) {        // Keeps the last match if nothing is found
+        if from >= len(e.history) {
+            from = len(e.history) - 1
+        }
+        for i := from; i >= 0; i -- {
+            if strings.Contains(e.history[i], string(query)) {
+                ind, match = i, e.history[i]
+                return
+            }
+        }
+    }
+
+    for {
+        e.write(fmt.Sprintf("\r(reverse-i-search)'%s': %s\x1b[K", string(query), match))
+        key, err := e.read_key()
+        if err != nil {
+            return "", false, KEY_NONE, err
+        }
+        switch key {
+        case KEY_CTRL_R:
+            if ind > 0 {
+                find(ind - 1)
+            }
+        case KEY_BACKSPACE, KEY_DEL:
+            if len(query) > 0 {
+                query = query[:len(query) - 1]
+                ind, match = len(e.history), ""
+                find(len(e.history) - 1)
+            }
+        case KEY_CTRL_C, KEY_CTRL_G:   // Cancel the search
+            return "", false, KEY_NONE, nil
+        case KEY_ENTER, '\n':
+            return match, true, KEY_NONE, nil
+        default:
+            if key >= ' ' {
+                query = append(query, rune(key))
+                find(ind)       // The current match may still do
+                continue
+            }
+            e.line = []rune(match)
+            e.pos = len(e.line)
+            return "", false, key, nil
+        }
+    }
+}
+
+// The characters that end a symbol
+func is_delim(r rune) bool {
+    return strings.ContainsRune("()[]{}\"'`~; \t", r)
+}
+
+func (e * Editor) complete() {
+    if e.Complete == nil {
+        return
+    }
+    start := e.pos
+    for start > 0 && ! is_delim(e.line[start - 1]) {
+        start --
+    }
+    word := string(e.line[start:e.pos])
+    cands := e.Complete(word)
+    if len(cands) == 0 {
+        return
+    }
+
+    // Complete up to the longest common prefix, in runes so that no character
+    // is split
+    prefix := []rune(cands[0])
+    for _, cand := range cands[1:] {
+        n := 0
+        for _, r := range cand {
+            if n == len(prefix) || prefix[n] != r {
+                break
+            }
+            n ++
+        }
+        prefix = prefix[:n]
+    }
+    if n := len([]rune(word)); len(prefix) > n {
+        e.insert(prefix[n:]...)
+    } else if len(cands) > 1 && e.tabbed {  // List candidates on the second tab
+        e.write("\n" + strings.Join(cands, "  ") + "\n")
+    }
+    if len(cands) == 1 {
+        e.insert(' ')
+    }
+}
+
+// Finds the bracket matching the one at pos, or -1
+func match_bracket(line []rune, pos int) int {
+    pairs := map[rune]rune{'(': ')', '[': ']', '{': '}', ')': '(', ']': '[', '}': '{'}
+    other, ok := pairs[line[pos]]
+    if ! ok {
+        return -1
+    }
+    dir := 1
+    if strings.ContainsRune(")]}", line[pos]) {
+        dir = -1
+    }
+    depth := 0
+    for i := pos; i >= 0 && i < len(line); i += dir {
+        switch line[i] {
+        case line[pos]:
+            depth ++
+        case other:
+            depth --
+            if depth == 0 {
+                return i
+            }
+        }
+    }
+    return -1
+}
+
+func (e * Editor) render(hilite bool) string {
+    match := -1
+    if hilite {
+        if e.pos > 0 && strings.ContainsRune(")]}", e.line[e.pos - 1]) {   // Just closed
+            match = match_bracket(e.line, e.pos - 1)
+        } else if e.pos < len(e.line) {
+            match = match_bracket(e.line, e.pos)
+        }
+    }
+    if match < 0 {
+        return string(e.line)
+    }
+    return string(e.line[:match]) + "\x1b[7m" + string(e.line[match]) + "\x1b[27m" + string(e.line[match + 1:])
+}
+
+func (e * Editor) redraw(hilite bool) {
+    s := "\r" + e.prompt + e.render(hilite) + "\x1b[K"
+    if back := len(e.line) - e.pos; back > 0 {
+        s += fmt.Sprintf("\x1b[%dD", back)
+    }
+    e.write(s)
+}
+
+func (e * Editor) refresh() {
+    e.redraw(true)
+}
+
+func (e * Editor) refresh_plain() {
+    e.redraw(false)
+}
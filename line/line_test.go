@@ -0,0 +1,152 @@
+package line
+
+import (
+    "bufio"
+    "bytes"
+    "io/ioutil"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// An editor reading the keys in input, as if from a terminal
+func test_editor(input string, history ...string) (*Editor, *bytes.Buffer) {
+    var out bytes.Buffer
+    e := &Editor{in: bufio.NewReader(strings.NewReader(input)), out: &out, history: history}
+    return e, &out
+}
+
+func TestMatchBracket(t *testing.T) {
+    line := []rune("(a [b {c}] (d))")
+    for _, test := range []struct{ pos, match int }{
+        {0, 14}, {14, 0}, {3, 9}, {9, 3}, {6, 8}, {8, 6}, {11, 13}, {13, 11},
+        {1, -1},        // Not a bracket
+    } {
+        if got := match_bracket(line, test.pos); got != test.match {
+            t.Errorf("match_bracket(%d) = %d, want %d", test.pos, got, test.match)
+        }
+    }
+    if got := match_bracket([]rune("((a)"), 0); got != -1 {
+        t.Errorf("Unclosed bracket matched %d", got)
+    }
+}
+
+func TestEdit(t *testing.T) {
+    for _, test := range []struct {
+        keys    string
+        line    string
+    }{
+        {"abc\r", "abc"},
+        {"abc\x02\x02X\r", "aXbc"},             // Ctrl-B
+        {"abc\x1b[D\x1b[DX\x1b[CY\r", "aXbYc"}, // Arrows
+        {"abc\x01X\x05Y\r", "XabcY"},           // Ctrl-A, Ctrl-E
+        {"abc\x7f\x7fd\r", "ad"},               // Backspace
+        {"abc\x01\x1b[3~\r", "bc"},             // Delete
+        {"ab cd\x17\r", "ab "},                 // Ctrl-W
+        {"abcd\x02\x02\x0b\r", "ab"},           // Ctrl-K
+        {"abcd\x02\x02\x15\r", "cd"},           // Ctrl-U
+        {"λx\x02y\r", "λyx"},
+    } {
+        e, _ := test_editor(test.keys)
+        if line, err := e.edit("> "); err != nil || line != test.line {
+            t.Errorf("%q gave %q, %v; want %q", test.keys, line, err, test.line)
+        }
+    }
+}
+
+func TestBrowseHistory(t *testing.T) {
+    e, _ := test_editor("\x1b[A\x1b[A\r", "one", "two")
+    if line, _ := e.edit("> "); line != "one" {
+        t.Errorf("Two ups gave %q", line)
+    }
+    e, _ = test_editor("new\x1b[A\x1b[B\r", "one")
+    if line, _ := e.edit("> "); line != "new" {
+        t.Errorf("Up and down gave %q", line)
+    }
+}
+
+func TestSearch(t *testing.T) {
+    history := []string{"(defn f [x] x)", "(println 1)", "(f 2)"}
+    for _, test := range []struct {
+        keys    string
+        line    string
+    }{
+        {"\x12f\r", "(f 2)"},
+        {"\x12f\x12\r", "(defn f [x] x)"},          // Ctrl-R again for an older match
+        {"\x12print\r", "(println 1)"},
+        {"\x12prx\x7f\r", "(println 1)"},           // x matches nothing, and is taken back
+        {"ab\x12f\x07\r", "ab"},                    // Ctrl-G cancels
+        // The key that ends the search is handled on the match
+        {"\x12print\x1b[D\x1b[DX\r", "(println X1)"},
+        {"\x12f 2\x01Y\r", "Y(f 2)"},
+    } {
+        e, _ := test_editor(test.keys, history...)
+        if line, err := e.edit("> "); err != nil || line != test.line {
+            t.Errorf("%q gave %q, %v; want %q", test.keys, line, err, test.line)
+        }
+    }
+}
+
+func TestComplete(t *testing.T) {
+    names := []string{"print", "println", "range", "λ-map", "λ-filter", "éa", "éb", "zé", "zè"}
+    complete := func (word string) []string {
+        cands := []string{}
+        for _, name := range names {
+            if strings.HasPrefix(name, word) {
+                cands = append(cands, name)
+            }
+        }
+        return cands
+    }
+    for _, test := range []struct {
+        keys    string
+        line    string
+    }{
+        {"(ra\t\r", "(range "},
+        {"(pr\t\r", "(print"},          // Up to what they share
+        {"(λ\t\r", "(λ-"},
+        {"(z\t\r", "(z"},            // é and è share a first byte, not a rune
+        {"(x\t\r", "(x"},
+        {"(ra\x01\x05\t\r", "(range "},
+    } {
+        e, _ := test_editor(test.keys)
+        e.Complete = complete
+        if line, err := e.edit("> "); err != nil || line != test.line {
+            t.Errorf("%q gave %q, %v; want %q", test.keys, line, err, test.line)
+        }
+    }
+    e, out := test_editor("(é\t\t\r")         // A second tab lists them
+    e.Complete = complete
+    if line, _ := e.edit("> "); line != "(é" || ! strings.Contains(out.String(), "\néa  éb\n") {
+        t.Errorf("Got %q, printed %q", line, out.String())
+    }
+}
+
+func TestHistoryFile(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "history")
+    lines := make([]string, 0, HISTORY_SIZE + 10)
+    for i := 0; i < HISTORY_SIZE + 10; i ++ {
+        lines = append(lines, strings.Repeat("x", i % 7 + 1))
+    }
+    ioutil.WriteFile(path, []byte(strings.Join(lines, "\n") + "\n\n"), 0600)
+
+    e := &Editor{hist_file: path, term: true}
+    e.load_history()
+    if ! reflect.DeepEqual(e.History(), lines[10:]) {
+        t.Errorf("Loaded %d lines", len(e.History()))
+    }
+    data, _ := ioutil.ReadFile(path)        // Cut down to the size
+    if got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); ! reflect.DeepEqual(got, lines[10:]) {
+        t.Errorf("Saved %d lines", len(got))
+    }
+
+    e.AddHistory("new")
+    e.AddHistory("new")     // Repeated
+    e.AddHistory("  ")
+    again := &Editor{hist_file: path, term: true}
+    again.load_history()
+    if h := again.History(); len(h) != HISTORY_SIZE || h[len(h) - 1] != "new" || h[len(h) - 2] != lines[len(lines) - 1] {
+        t.Errorf("History after adding: %d lines ending with %q", len(h), h[len(h) - 1])
+    }
+}
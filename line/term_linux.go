@@ -0,0 +1,42 @@
+// +build linux
+
+package line
+
+import (
+    "syscall"
+    "unsafe"
+)
+
+func ioctl(fd int, req uintptr, t *syscall.Termios) error {
+    _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(unsafe.Pointer(t)))
+    if errno != 0 {
+        return errno
+    }
+    return nil
+}
+
+func is_terminal(fd int) bool {
+    var t syscall.Termios
+    return ioctl(fd, syscall.TCGETS, &t) == nil
+}
+
+// Puts the terminal into raw mode; the returned function restores the old mode
+func make_raw(fd int) (func(), error) {
+    var old syscall.Termios
+    if err := ioctl(fd, syscall.TCGETS, &old); err != nil {
+        return nil, err
+    }
+
+    raw := old      // Output processing is kept so "\n" still starts a new line
+    raw.Iflag &^= syscall.BRKINT | syscall.ICRNL | syscall.INPCK | syscall.ISTRIP | syscall.IXON
+    raw.Cflag |= syscall.CS8
+    raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.IEXTEN | syscall.ISIG
+    raw.Cc[syscall.VMIN] = 1
+    raw.Cc[syscall.VTIME] = 0
+    if err := ioctl(fd, syscall.TCSETS, &raw); err != nil {
+        return nil, err
+    }
+    return func() {
+        ioctl(fd, syscall.TCSETS, &old)
+    }, nil
+}
@@ -0,0 +1,17 @@
+// +build !linux
+
+package line
+
+import (
+    "errors"
+)
+
+// Raw mode is only implemented for Linux; elsewhere the editor falls back to
+// reading plain lines
+func is_terminal(fd int) bool {
+    return false
+}
+
+func make_raw(fd int) (func(), error) {
+    return nil, errors.New("raw mode not supported on this platform")
+}
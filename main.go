@@ -1,47 +1,172 @@
 package main
 
 import (
+    "flag"
     "fmt"
+    "io/ioutil"
     "os"
-    "bufio"
 
-    "github.com/crides/gysp/parse"
     "github.com/crides/gysp/eval"
-    "github.com/crides/gysp/color"
 )
 
+const USAGE = `Usage: gysp [options] [script [args...]]
+       gysp test [options] [path...]
+
+Runs script, or the program piped to stdin; starts the REPL if there's no
+program. A script named "-" is read from stdin. *argv* is bound to the list
+of args. gysp test runs the tests of *_test.gy files (see gysp test -h).
+
+Options:
+`
+
 func main() {
-    Repl()
-    //code := `(println (+ 1 2 3) (* 2-3j 1+2j) (/ 2-3j 1+2j))`
-    //l := parse.NewLexer()
-    //fmt.Println(eval.Eval(parse.Parse(l.Lex(code)), eval.StandardEnv()))
+    if len(os.Args) > 1 && os.Args[1] == "test" {
+        os.Exit(test_main(os.Args[2:]))
+    }
+    expr := flag.String("e", "", "Evaluate `expr` instead of a script")
+    interactive := flag.Bool("i", false, "Start the REPL after running the program")
+    vm := flag.Bool("vm", false, "Compile to bytecode and run on the VM")
+    debug := flag.Bool("debug", false, "Run the program in the debugger, stopping at its first form")
+    profile := flag.Bool("profile", false, "Print the time spent in each function to stderr after the program")
+    folded := flag.String("folded", "gysp.folded", "With -profile, write the stacks for flame graphs to `file`")
+    cover := flag.Bool("cover", false, "Print the lines run of each file to stderr after the program")
+    cover_html := flag.String("coverhtml", "gysp-cover.html", "With -cover, write the files with the lines run highlighted to `file`")
+    flag.Usage = func() {
+        fmt.Fprint(os.Stderr, USAGE)
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+    args := flag.Args()
+    has_expr := false       // -e '' is still a program, just an empty one
+    flag.Visit(func (f *flag.Flag) {
+        if f.Name == "e" {
+            has_expr = true
+        }
+    })
+
+    // Find the program to run
+    name, code := "", ""
+    switch {
+    case has_expr:
+        name, code = "-e", *expr
+    case len(args) > 0:
+        name, args = args[0], args[1:]
+        code = read_file(name)
+    case ! is_terminal(os.Stdin):       // Piped in
+        name, code = "-", read_file("-")
+    }
+
+    it := eval.NewInterpreter()
+    it.VM = *vm
+    it.Define("*argv*", argv(args))
+    if name != "" {
+        if *debug {
+            new_repl(it).debugger().SetStep(eval.STEP_IN)
+        }
+        var prof *eval.Profiler
+        if *profile {
+            prof = eval.NewProfiler()
+            it.Profiler = prof
+        }
+        if *cover {
+            it.Cover = eval.NewCoverage()
+        }
+        status, exit := run_program(it, name, code)
+        if prof != nil {
+            it.Profiler = nil
+            write_profile(prof, *folded)
+        }
+        if it.Cover != nil {
+            write_cover(it.Cover, *cover_html)
+            it.Cover = nil
+        }
+        if exit {
+            os.Exit(status)
+        }
+        if *debug {
+            it.Debugger.SetStep(eval.STEP_CONTINUE)
+        }
+        if ! *interactive {
+            return
+        }
+    }
+    Repl(it)
+}
+
+// Prints the report of prof, and writes its stacks to the file folded
+func write_profile(prof *eval.Profiler, folded string) {
+    prof.Report(os.Stderr)
+    f, err := os.Create(folded)
+    if err == nil {
+        err = prof.WriteFolded(f)
+        if cerr := f.Close(); err == nil {
+            err = cerr
+        }
+    }
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "gysp:", err)
+    }
 }
 
-// Wrapper for the eval.Eval function
-func Eval(code string, lexer *parse.Lexer, env *eval.Env) *eval.Object {
-    return eval.Eval(parse.Parse(lexer.Lex(code)), env)
+// Prints the summary of cover, and writes the annotated files to html
+func write_cover(cover *eval.Coverage, html string) {
+    cover.Summary(os.Stderr)
+    f, err := os.Create(html)
+    if err == nil {
+        err = cover.WriteHTML(f)
+        if cerr := f.Close(); err == nil {
+            err = cerr
+        }
+    }
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "gysp:", err)
+    }
 }
 
-func Repl() {
-    // Repl constants
-    header := "Gysp 1.0 by Steven."
-    PS1 := " => "
-    //PS2 := "... "
+// The list of args as Gysp strings
+func argv(args []string) *eval.Object {
+    list := make([]*eval.Object, len(args))
+    for i, arg := range args {
+        list[i] = eval.NewStr(arg)
+    }
+    return eval.NewObject(eval.OBJECT_LIST, list)
+}
 
-    // Environments
-    input := bufio.NewScanner(os.Stdin)
-    lexer := parse.NewLexer()
-    env := eval.StandardEnv()
+func is_terminal(f *os.File) bool {
+    stat, err := f.Stat()
+    return err == nil && stat.Mode() & os.ModeCharDevice != 0
+}
 
-    fmt.Println(header)
-    fmt.Print(PS1)
-    for input.Scan() {
-        fmt.Println(color.Yellow("output:"))
-        ret_val := Eval(input.Text(), lexer, env)
-        fmt.Print(color.Green("returned: "))
-        fmt.Println(ret_val.GoString())
+func read_file(name string) string {
+    var (
+        code []byte
+        err error
+    )
+    if name == "-" {
+        code, err = ioutil.ReadAll(os.Stdin)
+    } else {
+        code, err = ioutil.ReadFile(name)
+    }
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "gysp:", err)
+        os.Exit(1)
+    }
+    return string(code)
+}
 
-        fmt.Print(PS1)
+// Runs a whole program; on errors and on (exit) the process should end, so
+// returns the exit status and true
+func run_program(it *eval.Interpreter, name, code string) (int, bool) {
+    prog, err := it.ParseFile(name, code)
+    if err == nil {
+        _, err = it.Run(prog)
+    }
+    if err != nil {
+        if code, ok := err.(eval.Exit); ok {
+            return int(code), true
+        }
+        fmt.Fprintf(it.Stderr, "gysp: %s: %v\n", name, err)
+        return 1, true
     }
-    fmt.Println("bye!")
+    return 0, false
 }
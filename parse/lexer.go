@@ -33,13 +33,52 @@ const (
     TOKEN
 )
 
+func (t TokenType) String() string {
+    switch t {
+    case TOKEN_NONE:
+        return "NONE"
+    case FUNC_BEGIN:
+        return "FUNC_BEGIN"
+    case FUNC_END:
+        return "FUNC_END"
+    case LIST_BEGIN:
+        return "LIST_BEGIN"
+    case LIST_END:
+        return "LIST_END"
+    case DICT_BEGIN:
+        return "DICT_BEGIN"
+    case DICT_END:
+        return "DICT_END"
+    case STRING:
+        return "STRING"
+    case COMPLEX:
+        return "COMPLEX"
+    case FLOAT:
+        return "FLOAT"
+    case INTEGER:
+        return "INTEGER"
+    case QUOTE:
+        return "QUOTE"
+    case QQUOTE:
+        return "QQUOTE"
+    case UNQUOTESP:
+        return "UNQUOTESP"
+    case UNQUOTE:
+        return "UNQUOTE"
+    case TOKEN:
+        return "TOKEN"
+    }
+    return fmt.Sprintf("TokenType(%d)", int(t))
+}
+
 type Token struct {
     typ     TokenType
     cont    string
+    pos     Pos
 }
 
 func NewToken(typ TokenType, tok string) *Token {
-    return &Token{typ, tok}
+    return &Token{typ, tok, Pos{}}
 }
 
 func (t * Token) Typ() TokenType {
@@ -50,10 +89,17 @@ func (t * Token) Cont() string {
     return t.cont
 }
 
+func (t * Token) Pos() Pos {
+    return t.pos
+}
+
 func (t * Token) String() string {
     return fmt.Sprintf("{%d %s}", t.typ, t.cont)
 }
 
+// The lexer scans the code by hand in one pass. Extra patterns can be added
+// with AddRegexp() and Ignore(); they are tried before the built-in rules at
+// each position.
 type Lexer struct {
     pats        []*re.Regexp
     types       []TokenType
@@ -67,29 +113,6 @@ type Lexer struct {
 func NewLexer() *Lexer {
     l := new(Lexer)
 
-    // Can be changed to adopt other situations
-    regexs := []string{
-        // Raw strings are better!
-        `\(`, `\)`, `\[`, `\]`, `\{`, `\}`, // Brackets
-
-        `"(?:[^"]|(?:\"))*?"`,              // String
-        `[+-]?(?:\d*\.)?\d+[+-]?(?:\d*\.)?\d+j`,    // Complex
-        `[+-]?\d*\.\d+`,                    // Float
-        `[+-]?\d+`,                         // Integer
-
-        `'(?:[^])}\s])`,                    // Quotes
-        "`(?:[^])}\\s])",   // ... except this
-        `~@(?:[^])}\s])`,
-        `~(?:[^])}\s])`,
-
-        `[^][(){}\s'";]+`,                  // Token
-    }
-    for i, pat := range regexs {
-        l.AddRegexp(TokenType(i), pat)
-    }
-    l.Ignore("\\s+")    // Ignore spaces
-    l.Ignore(";.*$")    // Ignore comments
-
     l.replacer = strings.NewReplacer(
         `\"`, `"`,      // Replace quote escapes
         `\a`, "\a",     // C escape characters
@@ -113,7 +136,7 @@ func NewLexer() *Lexer {
                 panic(`\x must be followed by exactly 2 hexdigits!`)
             }
             i, _ := strconv.ParseInt(s[2:], 16, 8)
-            return string(i)
+            return string(rune(i))
         },
 
         func(s string) string {
@@ -121,7 +144,7 @@ func NewLexer() *Lexer {
                 panic(`\u must be followed by exactly 4 hexdigits!`)
             }
             i, _ := strconv.ParseInt(s[2:], 16, 32)
-            return string(i)
+            return string(rune(i))
         },
 
         func(s string) string {
@@ -129,7 +152,7 @@ func NewLexer() *Lexer {
                 panic(`\u must be followed by exactly 8 hexdigits!`)
             }
             i, _ := strconv.ParseInt(s[2:], 16, 32)
-            return string(i)
+            return string(rune(i))
         },
 
         func(s string) string {
@@ -137,19 +160,21 @@ func NewLexer() *Lexer {
                 panic(`\ must be followed by exactly 3 octdigits!`)
             }
             i, _ := strconv.ParseInt(s[1:], 8, 8)
-            return string(i)
+            return string(rune(i))
         },
     }
     return l
 }
 
+// Adds a pattern for tokens of type typ
 func (l * Lexer) AddRegexp(typ TokenType, pat string) {
-    l.pats = append(l.pats, re.MustCompile(pat))
+    l.pats = append(l.pats, re.MustCompile(`\A(?:` + pat + `)`))
     l.types = append(l.types, typ)
 }
 
+// Adds a pattern for text to skip
 func (l * Lexer) Ignore(pat string) {
-    l.ignores = append(l.ignores, re.MustCompile(pat))
+    l.ignores = append(l.ignores, re.MustCompile(`\A(?:` + pat + `)`))
 }
 
 func (l * Lexer) ProcessString(s string) string {
@@ -160,30 +185,241 @@ func (l * Lexer) ProcessString(s string) string {
     return s
 }
 
-func (l * Lexer) Lex(code string) (toks []*Token) {
-    for inds := []int{0, 0}; len(code) > 0; code = code[inds[1]:] {
-        for i, pat := range l.pats {
-            if inds = pat.FindStringIndex(code); inds != nil && inds[0] == 0 && inds[1] > 0 {
-                typ, token := l.types[i], pat.FindString(code)
-                if typ >= QUOTE && typ <= UNQUOTE {    // Belong to the quotes
-                    inds[1] --      // Get rid of the last char
-                    token = token[:len(token) - 1]
-                } else if typ == STRING {
-                    token = l.ProcessString(token)
+// Tries the added patterns on code; returns the length matched and the token
+// (nil if ignored), or 0 if nothing matched
+func (l * Lexer) lex_extra(code string) (int, *Token) {
+    for i, pat := range l.pats {
+        if inds := pat.FindStringIndex(code); inds != nil && inds[1] > 0 {
+            typ, token := l.types[i], code[:inds[1]]
+            if typ >= QUOTE && typ <= UNQUOTE {    // Belong to the quotes
+                inds[1] --      // Get rid of the last char
+                token = token[:len(token) - 1]
+            } else if typ == STRING {
+                token = l.ProcessString(token)
+            }
+            return inds[1], NewToken(typ, token)
+        }
+    }
+    for _, pat := range l.ignores {
+        if inds := pat.FindStringIndex(code); inds != nil && inds[1] > 0 {
+            return inds[1], nil
+        }
+    }
+    return 0, nil
+}
+
+func bracket(c byte) TokenType {
+    switch c {
+    case '(':
+        return FUNC_BEGIN
+    case ')':
+        return FUNC_END
+    case '[':
+        return LIST_BEGIN
+    case ']':
+        return LIST_END
+    case '{':
+        return DICT_BEGIN
+    case '}':
+        return DICT_END
+    }
+    return TOKEN_NONE
+}
+
+func is_space(c byte) bool {    // Same as \s in regexps
+    return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+func is_digit(c byte) bool {
+    return c >= '0' && c <= '9'
+}
+
+// Whether c can follow a quote
+func is_quotable(c byte) bool {
+    return c != ']' && c != ')' && c != '}' && ! is_space(c)
+}
+
+func is_token_char(c byte) bool {
+    return ! is_space(c) && strings.IndexByte(`[](){}'";`, c) < 0
+}
+
+// Scans a closed string starting at i; returns its end, or -1
+func scan_string(code string, i int) int {
+    for i ++; i < len(code); i ++ {
+        switch code[i] {
+        case '\\':     // Skip the escaped char
+            i ++
+        case '"':
+            return i + 1
+        }
+    }
+    return -1
+}
+
+// Scans an unsigned real number (\d*\.\d+ or \d+) at i; returns its end, or -1
+func scan_real(code string, i int) int {
+    j := i
+    for j < len(code) && is_digit(code[j]) {
+        j ++
+    }
+    if j < len(code) && code[j] == '.' {
+        k := j + 1
+        for k < len(code) && is_digit(code[k]) {
+            k ++
+        }
+        if k > j + 1 {
+            return k
+        }
+    }
+    if j > i {
+        return j
+    }
+    return -1
+}
+
+// Whether s is an unsigned real number
+func is_real(s string) bool {
+    return len(s) > 0 && scan_real(s, 0) == len(s)
+}
+
+// Scans a complex like 1+2j (or 12j, which is 1+2j) starting after the sign
+// at i; returns its end, or -1
+func scan_complex(code string, i int) int {
+    j := i
+    for j < len(code) && (is_digit(code[j]) || code[j] == '.' || code[j] == '+' || code[j] == '-') {
+        j ++
+    }
+    if j >= len(code) || code[j] != 'j' {
+        return -1
+    }
+    num := code[i:j]
+    if k := strings.IndexAny(num, "+-"); k >= 0 {       // The sign separates the parts
+        if is_real(num[:k]) && is_real(num[k + 1:]) {
+            return j + 1
+        }
+        return -1
+    }
+    for k := 1; k < len(num); k ++ {
+        if is_real(num[:k]) && is_real(num[k:]) {
+            return j + 1
+        }
+    }
+    return -1
+}
+
+// Scans a number at i: an integer, a float or a complex. Returns the type and
+// the end, or TOKEN_NONE if there's no number.
+func scan_number(code string, i int) (TokenType, int) {
+    j := i
+    if j < len(code) && (code[j] == '+' || code[j] == '-') {
+        j ++
+    }
+    end := scan_real(code, j)
+    if end < 0 {
+        return TOKEN_NONE, i
+    }
+    if end < len(code) && ! is_space(code[end]) {
+        if cend := scan_complex(code, j); cend > 0 {
+            return COMPLEX, cend
+        }
+    }
+    if strings.IndexByte(code[j:end], '.') >= 0 {
+        return FLOAT, end
+    }
+    return INTEGER, end
+}
+
+// Scans a quote (', `, ~@ or ~) at i; it must be followed by something
+// quotable. Returns the type and the end, or TOKEN_NONE.
+func scan_quote(code string, i int) (TokenType, int) {
+    typ, end := TOKEN_NONE, i + 1
+    switch code[i] {
+    case '\'':
+        typ = QUOTE
+    case '`':
+        typ = QQUOTE
+    case '~':
+        typ = UNQUOTE
+        if end + 1 < len(code) && code[end] == '@' && is_quotable(code[end + 1]) {
+            typ, end = UNQUOTESP, end + 1
+        }
+    }
+    if typ == TOKEN_NONE || end >= len(code) || ! is_quotable(code[end]) {
+        return TOKEN_NONE, i
+    }
+    return typ, end
+}
+
+func (l * Lexer) Lex(code string) []*Token {
+    return l.LexFile("", code)
+}
+
+// Lexes the code of a file; the tokens know their lines in it
+func (l * Lexer) LexFile(file string, code string) (toks []*Token) {
+    line, counted := 1, 0       // The line of code[counted]
+    add := func(tok *Token, start int) {
+        line += strings.Count(code[counted:start], "\n")
+        counted = start
+        tok.pos = Pos{file, line}
+        toks = append(toks, tok)
+    }
+    extra := len(l.pats) > 0 || len(l.ignores) > 0
+    for i := 0; i < len(code); {
+        if extra {
+            if n, tok := l.lex_extra(code[i:]); n > 0 {
+                if tok != nil {
+                    add(tok, i)
                 }
-                toks = append(toks, NewToken(typ, token))
-                goto next
+                i += n
+                continue
             }
         }
-        for _, pat := range l.ignores {
-            if inds = pat.FindStringIndex(code); inds != nil && inds[0] == 0 && inds[1] > 0 {
-                goto next
+
+        c := code[i]
+        if is_space(c) {
+            i ++
+            continue
+        }
+        if c == ';' {       // Ignore comments (up to the end of line)
+            for i < len(code) && code[i] != '\n' {
+                i ++
+            }
+            continue
+        }
+        if typ := bracket(c); typ != TOKEN_NONE {
+            add(NewToken(typ, code[i:i + 1]), i)
+            i ++
+            continue
+        }
+        if c == '"' {
+            end := scan_string(code, i)
+            if end < 0 {
+                panic(IncompleteError("Premature end of input: Expect closed string!"))
             }
+            add(NewToken(STRING, l.ProcessString(code[i:end])), i)
+            i = end
+            continue
+        }
+        if typ, end := scan_number(code, i); typ != TOKEN_NONE {
+            add(NewToken(typ, code[i:end]), i)
+            i = end
+            continue
+        }
+        if typ, end := scan_quote(code, i); typ != TOKEN_NONE {
+            add(NewToken(typ, code[i:end]), i)
+            i = end
+            continue
+        }
+
+        end := i
+        for end < len(code) && is_token_char(code[end]) {
+            end ++
         }
-        if inds == nil {
+        if end == i {
             panic("Cannot identify the next token!")
         }
-next:
+        add(NewToken(TOKEN, code[i:end]), i)
+        i = end
     }
     return
 }
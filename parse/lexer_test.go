@@ -0,0 +1,164 @@
+package parse
+
+import (
+    "fmt"
+    re "regexp"
+    "strings"
+    "testing"
+)
+
+// A few forms with every kind of token
+const BENCH_CHUNK = `; Comment
+(defn fib [n]
+    (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2)))))
+(let [xs [1 2.5 -3 .5 1+2j] d {"a" "b\tc\"d" 'q 'r}]
+    (println xs d ` + "`" + `(a ~b ~@c)))
+`
+
+// The patterns of the regexp lexer the scanner replaced, in the order they
+// were tried, and the patterns it skipped
+var OLD_PATTERNS = []string{
+    `\(`, `\)`, `\[`, `\]`, `\{`, `\}`,
+    `"(?:[^"]|(?:\"))*?"`,
+    `[+-]?(?:\d*\.)?\d+[+-]?(?:\d*\.)?\d+j`,
+    `[+-]?\d*\.\d+`,
+    `[+-]?\d+`,
+    `'(?:[^])}\s])`,
+    "`(?:[^])}\\s])",
+    `~@(?:[^])}\s])`,
+    `~(?:[^])}\s])`,
+    `[^][(){}\s'";]+`,
+}
+var OLD_IGNORES = []string{`\s+`, `;.*`}
+
+// Lexes code like the regexp lexer did, with the lines of the tokens
+func old_lex(code string) []*Token {
+    pats, ignores := make([]*re.Regexp, 0), make([]*re.Regexp, 0)
+    for _, pat := range OLD_PATTERNS {
+        pats = append(pats, re.MustCompile(`\A(?:` + pat + `)`))
+    }
+    for _, pat := range OLD_IGNORES {
+        ignores = append(ignores, re.MustCompile(`\A(?:` + pat + `)`))
+    }
+    l := NewLexer()
+    toks := make([]*Token, 0)
+    line := 1
+    for len(code) > 0 {
+        n := 0
+        for i, pat := range pats {
+            if inds := pat.FindStringIndex(code); inds != nil && inds[1] > 0 {
+                typ, token := TokenType(i), code[:inds[1]]
+                n = inds[1]
+                if typ >= QUOTE && typ <= UNQUOTE {
+                    n --
+                    token = token[:n]
+                } else if typ == STRING {
+                    token = l.ProcessString(token)
+                }
+                toks = append(toks, &Token{typ, token, Pos{"", line}})
+                break
+            }
+        }
+        if n == 0 {
+            for _, pat := range ignores {
+                if inds := pat.FindStringIndex(code); inds != nil && inds[1] > 0 {
+                    n = inds[1]
+                    break
+                }
+            }
+        }
+        if n == 0 {
+            panic("Cannot identify the next token!")
+        }
+        line += strings.Count(code[:n], "\n")
+        code = code[n:]
+    }
+    return toks
+}
+
+func tokens_string(toks []*Token) string {
+    strs := make([]string, len(toks))
+    for i, tok := range toks {
+        strs[i] = fmt.Sprintf("%v %q %d", tok.typ, tok.cont, tok.pos.Line)
+    }
+    return strings.Join(strs, "\n")
+}
+
+var LEX_TESTS = []string{
+    // Brackets and names
+    `(foo [bar baz] {qux quux})`,
+    `(a-b c? d! *e* <= /)`,
+    // Comments
+    "; only a comment",
+    "(a ; b c\n d) ; e\n;f\nh",
+    "a;b\nc",
+    // Numbers
+    `1 22 333 -4 +5 0.5 .5 -.5 +1.25 -0.0`,
+    `1-2 a-1 -a - + -- 3.`,
+    `1+2j -1.5-2.5j .5+.5j 12j 1-2j -3+4j`,
+    `(+ 1 -2) [-1 -2.5 -1+1j]`,
+    // Quotes
+    "'a `(b ~c ~@d) '(1 2) '[x]",
+    // Strings with escapes
+    `"plain" "a\nb" "\t\a\e" "\x41é\U0001F600\101" ""`,
+    "(print \"line\none\" \"two\")",
+    // Lines
+    "(a\n  b\n\n  c)\n\n\"x\ny\" z\n; c\n   \t(d)",
+    strings.Replace(BENCH_CHUNK, `\"`, "", -1),   // See TestLexEscapedQuote
+}
+
+// The scanner gives the same tokens, on the same lines, as the regexp lexer
+func TestLexMatchesRegexps(t *testing.T) {
+    l := NewLexer()
+    for _, code := range LEX_TESTS {
+        want, got := tokens_string(old_lex(code)), tokens_string(l.Lex(code))
+        if got != want {
+            t.Errorf("%q lexed to\n%s\nwant\n%s", code, got, want)
+        }
+    }
+}
+
+// The regexp lexer ended strings at an escaped quote; the scanner doesn't
+func TestLexEscapedQuote(t *testing.T) {
+    toks := NewLexer().Lex(`"a\"b" c`)
+    want := "STRING \"a\\\"b\" 1\nTOKEN \"c\" 1"
+    if got := tokens_string(toks); got != want {
+        t.Errorf("Lexed to\n%s\nwant\n%s", got, want)
+    }
+}
+
+func TestLexFile(t *testing.T) {
+    toks := NewLexer().LexFile("f.gy", "(a\nb)")
+    if pos := toks[2].Pos(); pos.File != "f.gy" || pos.Line != 2 {
+        t.Errorf("b at %v, want f.gy:2", pos)
+    }
+}
+
+// About size bytes of code
+func bench_code(size int) string {
+    return strings.Repeat(BENCH_CHUNK, size / len(BENCH_CHUNK) + 1)
+}
+
+func BenchmarkLex(b *testing.B) {
+    for _, size := range []int{1 << 10, 1 << 20, 4 << 20} {
+        code := bench_code(size)
+        b.Run(fmt.Sprintf("%dK", size >> 10), func(b *testing.B) {
+            l := NewLexer()
+            b.SetBytes(int64(len(code)))
+            b.ReportAllocs()
+            for i := 0; i < b.N; i ++ {
+                l.Lex(code)
+            }
+        })
+    }
+}
+
+func BenchmarkParse(b *testing.B) {
+    code := bench_code(1 << 20)
+    l := NewLexer()
+    b.SetBytes(int64(len(code)))
+    b.ReportAllocs()
+    for i := 0; i < b.N; i ++ {
+        Parse(l.Lex(code))
+    }
+}
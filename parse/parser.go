@@ -3,6 +3,7 @@ package parse
 import (
     "fmt"
     "strconv"
+    "sync"
     re "regexp"
 
     "github.com/crides/gysp/color"
@@ -26,12 +27,49 @@ type Node interface {
     String()    string
 }
 
+// Interned symbol names; symbols with the same name have the same Sym, so
+// they can be compared and hashed as ints
+type Sym int
+
+var (
+    sym_lock    sync.RWMutex
+    sym_ids     = make(map[string]Sym)
+    sym_names   []string
+)
+
+func Intern(name string) Sym {
+    sym_lock.RLock()
+    sym, ok := sym_ids[name]
+    sym_lock.RUnlock()
+    if ok {
+        return sym
+    }
+
+    sym_lock.Lock()
+    defer sym_lock.Unlock()
+    if sym, ok := sym_ids[name]; ok {   // Interned while unlocked
+        return sym
+    }
+    sym = Sym(len(sym_names))
+    sym_ids[name] = sym
+    sym_names = append(sym_names, name)
+    return sym
+}
+
+func (s Sym) Name() string {
+    sym_lock.RLock()
+    defer sym_lock.RUnlock()
+    return sym_names[s]
+}
+
 type SymNode struct {       // Normal symbols
     Name    string
+    Sym     Sym
+    Pos     Pos
 }
 
 func NewSymNode(name string) *SymNode {
-    return &SymNode{name}
+    return &SymNode{name, Intern(name), Pos{}}
 }
 
 func (sn * SymNode) NodeTyp() NodeType {
@@ -44,13 +82,28 @@ func (sn * SymNode) String() string {
 
 var NIL_NODE = NewSymNode("nil")
 
+// Where a node is in the source; Line is 0 if it's unknown, like for the
+// code made by macros
+type Pos struct {
+    File    string
+    Line    int
+}
+
+func (p Pos) String() string {
+    if p.File == "" {
+        return fmt.Sprintf("line %d", p.Line)
+    }
+    return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
 type CallNode struct {      // Function and macro calls
     Fun     Node       // Function head
     Arglist []Node     // Function argument list
+    Pos     Pos        // Of the opening bracket
 }
 
 func NewCallNode(fun Node) *CallNode {
-    return &CallNode{fun, make([]Node, 0)}
+    return &CallNode{fun, make([]Node, 0), Pos{}}
 }
 
 func (cn * CallNode) NodeTyp() NodeType {
@@ -75,7 +128,7 @@ func NewCallNodeFromList(node *ListNode) *CallNode {
     if length < 1 {
         panic("CallNode's number of items must be greater than 1!")
     }
-    return &CallNode{list[0], list[1:]}
+    return &CallNode{list[0], list[1:], Pos{}}
 }
 
 func (cn * CallNode) AddArg(node Node) {
@@ -88,10 +141,11 @@ func (cn * CallNode) GetArgs() []Node {
 
 type ListNode struct {
     List    []Node     // The contents
+    Pos     Pos        // Of the opening bracket
 }
 
 func NewListNode() *ListNode {
-    return &ListNode{make([]Node, 0)}
+    return &ListNode{make([]Node, 0), Pos{}}
 }
 
 func (ln * ListNode) NodeTyp() NodeType {
@@ -112,10 +166,12 @@ func (ln * ListNode) GetList() []Node {
 
 type DictNode struct {
     Dict    map[Node]Node
+    Keys    []Node          // In the order they were set, so entries are evaluated in source order
+    Pos     Pos             // Of the opening bracket
 }
 
 func NewDictNode() *DictNode {
-    return &DictNode{make(map[Node]Node)}
+    return &DictNode{make(map[Node]Node), nil, Pos{}}
 }
 
 func NewDictNodeFromList(node *ListNode) *DictNode {
@@ -140,6 +196,9 @@ func (dn * DictNode) String() string {
 }
 
 func (dn * DictNode) Set(key, val Node) {
+    if _, ok := dn.Dict[key]; ! ok {
+        dn.Keys = append(dn.Keys, key)
+    }
     dn.Dict[key] = val
 }
 
@@ -149,10 +208,11 @@ func (dn * DictNode) GetDict() map[Node]Node {
 
 type LiteralNode struct {       // A node that represents a literal other than lists and dicts
     Val     interface{}
+    Pos     Pos
 }
 
 func NewLiteralNode(val interface{}) *LiteralNode {
-    return &LiteralNode{val}
+    return &LiteralNode{val, Pos{}}
 }
 
 func (ln * LiteralNode) NodeTyp() NodeType {
@@ -177,6 +237,49 @@ func (ln * LiteralNode) GetVal() interface{} {
     return ln.Val
 }
 
+// Raised (as a panic) when the input ends before every bracket is closed, so
+// that callers like the REPL can ask for more input instead of failing
+type IncompleteError string
+
+func (e IncompleteError) Error() string {
+    return string(e)
+}
+
+// Sets the position of a node to that of its token (the opening bracket for
+// calls, lists and dicts)
+func set_pos(node Node, token *Token) {
+    switch n := node.(type) {
+    case *CallNode:
+        n.Pos = token.Pos()
+    case *ListNode:
+        n.Pos = token.Pos()
+    case *DictNode:
+        n.Pos = token.Pos()
+    case *SymNode:
+        n.Pos = token.Pos()
+    case *LiteralNode:
+        n.Pos = token.Pos()
+    }
+}
+
+// The literal of a string or number token
+func literal(token *Token) *LiteralNode {
+    switch token.Typ() {
+    case INTEGER:
+        i, _ := strconv.Atoi(token.Cont())
+        return NewLiteralNode(i)
+    case FLOAT:
+        f, _ := strconv.ParseFloat(token.Cont(), 64)
+        return NewLiteralNode(f)
+    case COMPLEX:
+        subs := re.MustCompile(`([+-]?(?:\d*\.)?\d+)([+-]?(?:\d*\.)?\d+)j`).FindStringSubmatch(token.Cont())
+        r, _ := strconv.ParseFloat(subs[1], 64)
+        i, _ := strconv.ParseFloat(subs[2], 64)
+        return NewLiteralNode(complex(r, i))
+    }
+    return NewLiteralNode(token.Cont())
+}
+
 func Parse(tokens []*Token) Node {
     node, _ := parse(tokens, TOKEN_NONE)
     return node
@@ -190,6 +293,7 @@ func parse(tokens []*Token, until TokenType) (Node, int) {
         switch t := token.Typ(); t {
         case FUNC_BEGIN, LIST_BEGIN, DICT_BEGIN:
             next, advance := parse(tokens[i + 1:], t + 1)  // Skip the left brac in the recur
+            set_pos(next, token)
             root.Add(next)
             i += advance + 1
         case FUNC_END, LIST_END, DICT_END:
@@ -210,20 +314,12 @@ func parse(tokens []*Token, until TokenType) (Node, int) {
             //if until == TOKEN_NONE {
             //    return next, 1
             //}
+            set_pos(next, token)
+            root.Add(next)
+        case STRING, INTEGER, FLOAT, COMPLEX:
+            next := literal(token)
+            set_pos(next, token)
             root.Add(next)
-        case STRING:
-            root.Add(NewLiteralNode(token.Cont()))
-        case INTEGER:
-            i, _ := strconv.Atoi(token.Cont())
-            root.Add(NewLiteralNode(i))
-        case FLOAT:
-            f, _ := strconv.ParseFloat(token.Cont(), 64)
-            root.Add(NewLiteralNode(f))
-        case COMPLEX:
-            subs := re.MustCompile(`([+-]?(?:\d*\.)?\d+)([+-]?(?:\d*\.)?\d+)j`).FindStringSubmatch(token.Cont())
-            r, _ := strconv.ParseFloat(subs[1], 64)
-            i, _ := strconv.ParseFloat(subs[2], 64)
-            root.Add(NewLiteralNode(complex(r, i)))
         case QUOTE, QQUOTE, UNQUOTE, UNQUOTESP:
             symbol := ""
             switch t {
@@ -237,6 +333,7 @@ func parse(tokens []*Token, until TokenType) (Node, int) {
                 symbol = "unquote-splice"
             }
             cn := NewCallNode(NewSymNode(symbol))
+            cn.Pos = token.Pos()
             next := tokens[i + 1]
             var (
                 sub Node
@@ -245,9 +342,11 @@ func parse(tokens []*Token, until TokenType) (Node, int) {
             switch next.Typ() {
             case FUNC_BEGIN, LIST_BEGIN, DICT_BEGIN:
                 sub, advance = parse(tokens[i + 2:], next.Typ() + 1)
+                set_pos(sub, next)
                 advance += 2        // Skipped the quote and left brac
             default:
                 sub, advance = NewSymNode(tokens[i + 1].Cont()), 1
+                set_pos(sub, next)
             }
             if next == nil {
                 panic("Expected item after " + symbol)
@@ -262,5 +361,5 @@ func parse(tokens []*Token, until TokenType) (Node, int) {
     if until == TOKEN_NONE {
         return root, len(tokens)
     }
-    panic("Premature end of input: Expect closed parenthese!")
+    panic(IncompleteError("Premature end of input: Expect closed parenthese!"))
 }
@@ -0,0 +1,309 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/crides/gysp/parse"
+    "github.com/crides/gysp/eval"
+    "github.com/crides/gysp/color"
+    "github.com/crides/gysp/line"
+)
+
+// Names bound to the most recent results
+var RESULT_VARS = []string{"*1", "*2", "*3"}
+
+type repl struct {
+    lexer   *parse.Lexer
+    it      *eval.Interpreter
+    argv    *eval.Object    // Kept over :reset
+    editor  *line.Editor
+    loaded  string          // The last file loaded, for :reload
+}
+
+// A REPL command; arg is the rest of the line
+type command struct {
+    name    string
+    arg     string          // Argument description for :help
+    help    string
+    run     func(r *repl, arg string)
+}
+
+var commands []*command
+
+func init() {       // Set in init() because :help refers to commands
+    commands = []*command{
+        {"load", "file", "Evaluate a file", (*repl).load},
+        {"reload", "", "Evaluate the last loaded file again", (*repl).reload},
+        {"env", "", "List the global bindings", (*repl).show_env},
+        {"type", "expr", "Show the type of the value of expr", (*repl).show_type},
+        {"time", "expr", "Evaluate expr and show how long it took", (*repl).time},
+        {"ast", "expr", "Show the parse tree of expr", (*repl).show_ast},
+        {"tokens", "expr", "Show the tokens of expr", (*repl).show_tokens},
+        {"break", "[file:]line", "Stop at a line", (*repl).set_break},
+        {"clear", "[file:]line", "Remove a breakpoint, or all of them", (*repl).clear_break},
+        {"breaks", "", "List the breakpoints", (*repl).show_breaks},
+        {"debug", "[expr]", "Step through expr; without it, let (breakpoint) stop", (*repl).debug},
+        {"reset", "", "Start over with a fresh environment", (*repl).reset},
+        {"quit", "", "Exit the REPL", (*repl).quit},
+        {"help", "", "Show this message", (*repl).help},
+    }
+}
+
+func new_repl(it *eval.Interpreter) *repl {
+    r := &repl{lexer: parse.NewLexer(), it: it, argv: it.Env.GetVar("*argv*")}
+    for _, name := range RESULT_VARS {
+        r.it.Define(name, eval.GYSP_NIL)
+    }
+    return r
+}
+
+// Lex and parse the code; returns nil if the code is not a complete form yet
+func read(code string, lexer *parse.Lexer) (prog *parse.ListNode) {
+    defer func() {
+        if err := recover(); err != nil {
+            if _, ok := err.(parse.IncompleteError); ! ok {
+                panic(err)
+            }
+            prog = nil
+        }
+    }()
+    return parse.Parse(lexer.Lex(code)).(*parse.ListNode)
+}
+
+// Reports an error; (exit) ends the REPL right away
+func print_err(err interface{}) {
+    if code, ok := err.(eval.Exit); ok {
+        fmt.Println("bye!")
+        os.Exit(int(code))
+    }
+    fmt.Println(color.Red(fmt.Sprint("error: ", err)))
+}
+
+// Evaluates a complete program, reporting errors instead of crashing the REPL
+func (r * repl) run(prog *parse.ListNode) {
+    if len(prog.List) == 0 {    // Blank lines or comments only
+        return
+    }
+    fmt.Println(color.Yellow("output:"))
+    ret_val, err := r.it.Run(prog)
+    if err != nil {
+        print_err(err)
+        return
+    }
+    fmt.Print(color.Green("returned: "))
+    fmt.Println(ret_val.GoString())
+    r.push_result(ret_val)
+}
+
+func (r * repl) push_result(val *eval.Object) {
+    for i := len(RESULT_VARS) - 1; i > 0; i -- {
+        r.it.Define(RESULT_VARS[i], r.it.Env.GetVar(RESULT_VARS[i - 1]))
+    }
+    r.it.Define(RESULT_VARS[0], val)
+}
+
+// Parses the argument of a command; it must be a complete expression
+func (r * repl) parse_arg(arg string) *parse.ListNode {
+    prog := read(arg, r.lexer)
+    if prog == nil {
+        panic("Incomplete expression!")
+    }
+    if len(prog.List) == 0 {
+        panic("Expected an expression!")
+    }
+    return prog
+}
+
+// Evaluates a parsed argument of a command
+func (r * repl) eval(prog *parse.ListNode) *eval.Object {
+    val, err := r.it.Run(prog)
+    if err != nil {
+        panic(err)
+    }
+    return val
+}
+
+// Runs a meta-command line (without the colon)
+func (r * repl) command(cmdline string) {
+    defer func() {
+        if err := recover(); err != nil {
+            print_err(err)
+        }
+    }()
+
+    name, arg := cmdline, ""
+    if i := strings.IndexAny(cmdline, " \t"); i >= 0 {
+        name, arg = cmdline[:i], strings.TrimSpace(cmdline[i + 1:])
+    }
+    for _, cmd := range commands {
+        if cmd.name == name {
+            cmd.run(r, arg)
+            return
+        }
+    }
+    panic(fmt.Sprintf("Unknown command ':%s'; try ':help'", name))
+}
+
+func (r * repl) load(arg string) {
+    if arg == "" {
+        panic("Usage: :load file")
+    }
+    r.load_file(arg)
+    r.loaded = arg          // Only once it has loaded, or :reload would retry a bad path
+}
+
+func (r * repl) reload(string) {
+    if r.loaded == "" {
+        panic("No file loaded yet!")
+    }
+    r.load_file(r.loaded)
+}
+
+func (r * repl) load_file(path string) {
+    if _, err := r.it.EvalFile(path); err != nil {
+        panic(err)
+    }
+    fmt.Println(color.Green("loaded " + path))
+}
+
+func (r * repl) show_env(string) {
+    for _, name := range r.it.Env.Names() {
+        fmt.Printf("%s = %s\n", name, r.it.Env.GetVar(name).GoString())
+    }
+}
+
+func (r * repl) show_type(arg string) {
+    fmt.Println(r.eval(r.parse_arg(arg)).Typ())
+}
+
+func (r * repl) time(arg string) {
+    prog := r.parse_arg(arg)
+    start := time.Now()
+    ret_val := r.eval(prog)
+    elapsed := time.Since(start)
+    fmt.Print(color.Green("returned: "))
+    fmt.Println(ret_val.GoString())
+    fmt.Println(color.Yellow("time: ") + elapsed.String())
+    r.push_result(ret_val)
+}
+
+func (r * repl) show_ast(arg string) {
+    for _, node := range r.parse_arg(arg).List {
+        fmt.Println(node)
+    }
+}
+
+func (r * repl) show_tokens(arg string) {
+    for _, tok := range r.lexer.Lex(arg) {
+        fmt.Printf("%-12v %q\n", tok.Typ(), tok.Cont())
+    }
+}
+
+func (r * repl) reset(string) {
+    r.it.Reset()
+    r.it.Define("*argv*", r.argv)
+    for _, name := range RESULT_VARS {
+        r.it.Define(name, eval.GYSP_NIL)
+    }
+}
+
+func (r * repl) quit(string) {
+    fmt.Println("bye!")
+    os.Exit(0)
+}
+
+func (r * repl) help(string) {
+    for _, cmd := range commands {
+        usage := ":" + cmd.name
+        if cmd.arg != "" {
+            usage += " " + cmd.arg
+        }
+        fmt.Printf("%-14s %s\n", usage, cmd.help)
+    }
+    fmt.Println("*1, *2 and *3 are bound to the last three results.")
+}
+
+// Where the REPL keeps its history; empty if there's no home directory
+func history_file() string {
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return ""
+    }
+    return filepath.Join(home, ".gysp_history")
+}
+
+func Repl(it *eval.Interpreter) {
+    // Repl constants
+    header := "Gysp 1.0 by Steven."
+    PS1 := " => "
+    PS2 := "... "
+
+    // Environments
+    r := new_repl(it)
+    r.editor = line.NewEditor(history_file())
+    r.editor.Complete = func(word string) []string {
+        cands := make([]string, 0)
+        if strings.HasPrefix(word, ":") {
+            for _, cmd := range commands {
+                if strings.HasPrefix(":" + cmd.name, word) {
+                    cands = append(cands, ":" + cmd.name)
+                }
+            }
+            return cands
+        }
+        for _, name := range append(eval.SpecialForms(), r.it.Env.Names()...) {
+            if strings.HasPrefix(name, word) {
+                cands = append(cands, name)
+            }
+        }
+        return cands
+    }
+
+    fmt.Println(header)
+    prompt := PS1
+    code := ""      // Lines of the form being read
+    for {
+        text, err := r.editor.ReadLine(prompt)
+        if err == line.ErrInterrupt {   // Drop the form being read
+            prompt, code = PS1, ""
+            continue
+        } else if err == io.EOF {
+            break
+        } else if err != nil {
+            print_err(err)
+            break
+        }
+
+        if code == "" && strings.HasPrefix(strings.TrimSpace(text), ":") {
+            r.command(strings.TrimSpace(text)[1:])
+            continue
+        }
+
+        code += text + "\n"
+        var prog *parse.ListNode
+        func() {
+            defer func() {      // Syntax errors drop the whole form
+                if err := recover(); err != nil {
+                    print_err(err)
+                    code = ""
+                }
+            }()
+            prog = read(code, r.lexer)
+        }()
+
+        if prog == nil && code != "" {  // Keep reading until the brackets balance
+            prompt = PS2
+            continue
+        }
+        if prog != nil {
+            r.run(prog)
+        }
+        prompt, code = PS1, ""
+    }
+    fmt.Println("bye!")
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+    "context"
+    "encoding/xml"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/crides/gysp/eval"
+    "github.com/crides/gysp/color"
+)
+
+const TEST_USAGE = `Usage: gysp test [options] [path...]
+
+Runs the tests (defined with deftest) of the *_test.gy files in the paths,
+which can be files or directories; the current directory by default. Each
+file is run in an interpreter of its own.
+
+Options:
+`
+
+// The tests of a file, run
+type test_file struct {
+    path    string
+    results []*eval.TestResult
+    err     error       // Raised loading the file
+    time    time.Duration
+    lines   []string    // Of the source, to show failures
+}
+
+func (f * test_file) failed() int {
+    n := 0
+    for _, res := range f.results {
+        if ! res.Passed() {
+            n ++
+        }
+    }
+    return n
+}
+
+// Runs gysp test with args; returns the exit status
+func test_main(args []string) int {
+    flags := flag.NewFlagSet("gysp test", flag.ExitOnError)
+    verbose := flags.Bool("v", false, "Show every test, not only those that fail")
+    junit := flags.String("junit", "", "Write the results as JUnit XML to `file`")
+    cover := flags.Bool("cover", false, "Print the lines run of each file to stderr after the tests")
+    cover_html := flags.String("coverhtml", "gysp-cover.html", "With -cover, write the files with the lines run highlighted to `file`")
+    flags.Usage = func() {
+        fmt.Fprint(os.Stderr, TEST_USAGE)
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+    paths := flags.Args()
+    if len(paths) == 0 {
+        paths = []string{"."}
+    }
+
+    files, err := find_tests(paths)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "gysp:", err)
+        return 1
+    }
+    var coverage *eval.Coverage
+    if *cover {
+        coverage = eval.NewCoverage()
+    }
+    status := 0
+    runs := make([]*test_file, len(files))
+    for i, path := range files {
+        runs[i] = run_tests(path, coverage)
+        report_tests(runs[i], *verbose)
+        if runs[i].err != nil || runs[i].failed() > 0 {
+            status = 1
+        }
+    }
+    if coverage != nil {
+        write_cover(coverage, *cover_html)
+    }
+    if *junit != "" {
+        if err := write_junit(runs, *junit); err != nil {
+            fmt.Fprintln(os.Stderr, "gysp:", err)
+            status = 1
+        }
+    }
+    return status
+}
+
+// The sorted *_test.gy files in paths
+func find_tests(paths []string) ([]string, error) {
+    files := make([]string, 0)
+    for _, path := range paths {
+        info, err := os.Stat(path)
+        if err != nil {
+            return nil, err
+        }
+        if ! info.IsDir() {
+            files = append(files, path)
+            continue
+        }
+        err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+            if err == nil && ! info.IsDir() && strings.HasSuffix(file, "_test.gy") {
+                files = append(files, file)
+            }
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+    }
+    sort.Strings(files)
+    return files, nil
+}
+
+// Loads a file in a fresh interpreter and runs its tests
+func run_tests(path string, coverage *eval.Coverage) *test_file {
+    run := &test_file{path: path}
+    if src, err := ioutil.ReadFile(path); err == nil {
+        run.lines = strings.Split(string(src), "\n")
+    }
+    start := time.Now()
+    it := eval.NewInterpreter()
+    it.Cover = coverage
+    it.Define("*argv*", argv(nil))
+    if _, run.err = it.EvalFile(path); run.err == nil {
+        run.results = it.RunTests(context.Background())
+    }
+    run.time = time.Since(start)
+    return run
+}
+
+// Line n of the source of run, or ""
+func (run * test_file) line(n int) string {
+    if n <= 0 || n > len(run.lines) {
+        return ""
+    }
+    return strings.TrimSpace(run.lines[n - 1])
+}
+
+func report_tests(run *test_file, verbose bool) {
+    if run.err != nil {
+        fmt.Printf("%s %s: %v\n", color.Red("FAIL"), run.path, run.err)
+        return
+    }
+    for _, res := range run.results {
+        if res.Passed() {
+            if verbose {
+                fmt.Printf("--- %s: %s (%v)\n", color.Green("PASS"), res.Test.Name, res.Time)
+            }
+            continue
+        }
+        fmt.Printf("--- %s: %s (%v)\n", color.Red("FAIL"), res.Test.Name, res.Test.Pos)
+        for _, f := range res.Failures {
+            msg := f.Msg
+            if len(f.Context) > 0 {
+                msg = strings.Join(f.Context, " > ") + ": " + msg
+            }
+            fmt.Printf("    %v: %s\n", f.Pos, msg)
+            if src := run.line(f.Pos.Line); src != "" {
+                fmt.Printf("        %s\n", src)
+            }
+            if f.Expected != "" {
+                fmt.Printf("        expected: %s\n", f.Expected)
+                fmt.Printf("        actual:   %s\n", f.Actual)
+            }
+            if f.Diff != "" {
+                fmt.Printf("        diff:     %s\n", f.Diff)
+            }
+        }
+        if res.Err != nil {
+            fmt.Printf("    error: %v\n", res.Err)
+        }
+    }
+    if failed := run.failed(); failed > 0 {
+        fmt.Printf("%s %s: %d of %d tests failed (%v)\n", color.Red("FAIL"), run.path, failed, len(run.results), run.time)
+    } else {
+        fmt.Printf("%s   %s: %d tests (%v)\n", color.Green("ok"), run.path, len(run.results), run.time)
+    }
+}
+
+// JUnit XML, as read by CI servers
+type junit_suites struct {
+    XMLName xml.Name        `xml:"testsuites"`
+    Suites  []junit_suite   `xml:"testsuite"`
+}
+
+type junit_suite struct {
+    Name        string          `xml:"name,attr"`
+    Tests       int             `xml:"tests,attr"`
+    Failures    int             `xml:"failures,attr"`
+    Errors      int             `xml:"errors,attr"`
+    Time        string          `xml:"time,attr"`
+    Cases       []junit_case    `xml:"testcase"`
+}
+
+type junit_case struct {
+    Name        string          `xml:"name,attr"`
+    Classname   string          `xml:"classname,attr"`
+    File        string          `xml:"file,attr,omitempty"`
+    Line        int             `xml:"line,attr,omitempty"`
+    Time        string          `xml:"time,attr"`
+    Failure     *junit_failure  `xml:"failure,omitempty"`
+    Error       *junit_failure  `xml:"error,omitempty"`
+}
+
+type junit_failure struct {
+    Message     string  `xml:"message,attr"`
+    Text        string  `xml:",chardata"`
+}
+
+func seconds(d time.Duration) string {
+    return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func write_junit(runs []*test_file, path string) error {
+    suites := junit_suites{}
+    for _, run := range runs {
+        suite := junit_suite{Name: run.path, Time: seconds(run.time)}
+        if run.err != nil {     // As a test of its own
+            suite.Tests, suite.Errors = 1, 1
+            suite.Cases = append(suite.Cases, junit_case{Name: "(load)", Classname: run.path, File: run.path,
+                Time: seconds(run.time), Error: &junit_failure{run.err.Error(), run.err.Error()}})
+        }
+        for _, res := range run.results {
+            c := junit_case{Name: res.Test.Name, Classname: run.path, File: run.path,
+                Line: res.Test.Pos.Line, Time: seconds(res.Time)}
+            if len(res.Failures) > 0 {
+                msgs := make([]string, len(res.Failures))
+                for i, f := range res.Failures {
+                    msgs[i] = f.Error()
+                }
+                c.Failure = &junit_failure{res.Failures[0].Error(), strings.Join(msgs, "\n")}
+                suite.Failures ++
+            }
+            if res.Err != nil {
+                c.Error = &junit_failure{res.Err.Error(), res.Err.Error()}
+                suite.Errors ++
+            }
+            suite.Tests ++
+            suite.Cases = append(suite.Cases, c)
+        }
+        suites.Suites = append(suites.Suites, suite)
+    }
+    out, err := xml.MarshalIndent(suites, "", "  ")
+    if err != nil {
+        return err
+    }
+    return ioutil.WriteFile(path, append([]byte(xml.Header), append(out, '\n')...), 0644)
+}
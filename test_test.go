@@ -0,0 +1,67 @@
+package main
+
+import (
+    "encoding/xml"
+    "io/ioutil"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestJUnit(t *testing.T) {
+    dir := t.TempDir()
+    a, b := filepath.Join(dir, "a_test.gy"), filepath.Join(dir, "b_test.gy")
+    ioutil.WriteFile(a, []byte(`(deftest ok (assert= 1 1))
+(deftest bad (assert= 1 2) (assert= 3 4))
+(deftest broken (undefined-fn))
+`), 0644)
+    ioutil.WriteFile(b, []byte(`(deftest unclosed`), 0644)
+    files, err := find_tests([]string{dir})
+    if err != nil {
+        t.Fatal(err)
+    }
+    runs := make([]*test_file, len(files))
+    for i, file := range files {
+        runs[i] = run_tests(file, nil)
+    }
+    out := filepath.Join(dir, "junit.xml")
+    if err := write_junit(runs, out); err != nil {
+        t.Fatal(err)
+    }
+    data, err := ioutil.ReadFile(out)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if ! strings.HasPrefix(string(data), xml.Header + "<testsuites>") {
+        t.Errorf("Starts with %q", data[:60])
+    }
+
+    var suites junit_suites
+    if err := xml.Unmarshal(data, &suites); err != nil {
+        t.Fatal(err)
+    }
+    if len(suites.Suites) != 2 {
+        t.Fatalf("%d suites, want 2", len(suites.Suites))
+    }
+    sa, sb := suites.Suites[0], suites.Suites[1]
+    if sa.Name != a || sa.Tests != 3 || sa.Failures != 1 || sa.Errors != 1 || len(sa.Cases) != 3 {
+        t.Errorf("Suite %+v", sa)
+    } else {
+        ok, bad, broken := sa.Cases[0], sa.Cases[1], sa.Cases[2]
+        if ok.Name != "ok" || ok.Classname != a || ok.File != a || ok.Line != 1 || ok.Failure != nil || ok.Error != nil {
+            t.Errorf("Passing case %+v", ok)
+        }
+        if bad.Line != 2 || bad.Failure == nil || bad.Error != nil ||
+            bad.Failure.Message != a + ":2: assert= failed: expected 1, got 2" ||
+            bad.Failure.Text != bad.Failure.Message + "\n" + a + ":2: assert= failed: expected 3, got 4" {
+            t.Errorf("Failing case %+v %+v", bad, bad.Failure)
+        }
+        if broken.Failure != nil || broken.Error == nil || broken.Error.Message != "Variable undefined-fn not defined!" {
+            t.Errorf("Broken case %+v", broken)
+        }
+    }
+    if sb.Name != b || sb.Tests != 1 || sb.Errors != 1 || len(sb.Cases) != 1 || sb.Cases[0].Name != "(load)" ||
+        sb.Cases[0].Error == nil || ! strings.Contains(sb.Cases[0].Error.Message, "Premature end of input") {
+        t.Errorf("Suite of a file that doesn't load %+v", sb)
+    }
+}